@@ -0,0 +1,147 @@
+// Package artifact defines the information taktool collects from installable packages
+// (APK, IPA, MSI or custom types) and the readers that collect it.
+//
+// Readers are registered with Register and selected per file with ForFile. Programs
+// embedding taktool can register their own readers for new package types.
+package artifact
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/pvarki/golang-tak-taktool/manifest"
+)
+
+// Info of a single package, one row in product.inf
+type Info struct {
+	Platform    string
+	Type        string
+	Package     string
+	DisplayName string
+	Version     string
+	Revision    string
+	ApkPath     string
+	IconPath    string
+	Description string
+	Hash        string
+	OsReq       int
+	TakReq      string
+	Size        int
+
+	// Name of the reader that read the package, e.g. "apk"
+	Format string
+	// File the package is read from, if it is not ApkPath, e.g. when packages are copied to an output directory
+	SourcePath string
+
+	// Manifest details, not written to product.inf
+	Permissions        []string
+	Features           []string
+	ExportedComponents []string
+	Debuggable         bool
+	TestOnly           bool
+	MinSdk             int
+	TargetSdk          int
+	// Native library ABIs from lib/<abi>/ entries, empty if the apk has no native libraries
+	Abis            []string
+	NativeLibraries []string
+	// SHA-256 fingerprint of the signer certificate, empty if the apk is not signed
+	SignerFingerprint string
+	// ATAK extensions declared in assets/plugin.xml
+	Extensions []manifest.PluginExtension
+	// Split name for split apks, and if a base apk requires its splits
+	Split          string
+	RequiresSplits bool
+	// Manufacturer of Windows installers
+	Vendor string
+
+	// PNG icon read together with the package metadata. If it is set, Reader.Icon is not used.
+	IconData []byte `json:"-"`
+}
+
+// Reader reads a package type. Readers are used from several goroutines at the same time.
+type Reader interface {
+	// Name of the package type, stored in Info.Format
+	Name() string
+	// Detect tells if the reader can read the file
+	Detect(path string) bool
+	// Read package metadata. Path, size and hash are filled in by the caller.
+	Read(path string) (Info, error)
+	// Icon returns the png icon of the package, or nil if it has none
+	Icon(path string, info Info) ([]byte, error)
+	// Hash returns the SHA-256 hash of the package as lowercase hex
+	Hash(path string) (string, error)
+}
+
+// DataReader is implemented by readers that can read the package from an open file.
+// The file is then opened only once, and it is not loaded into memory.
+type DataReader interface {
+	Reader
+	// Read package metadata from the open file of the given size
+	ReadData(path string, r io.ReaderAt, size int64) (Info, error)
+}
+
+var (
+	mu      sync.RWMutex
+	readers []Reader
+)
+
+// Register adds a reader. Readers registered later are tried first, so a reader
+// can replace a built-in reader of the same package type.
+func Register(r Reader) {
+	mu.Lock()
+	defer mu.Unlock()
+	readers = append(readers, r)
+}
+
+// ForFile returns the reader for the file, or nil if no reader can read it
+func ForFile(path string) Reader {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, r := range slices.Backward(readers) {
+		if r.Detect(path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Lookup returns the reader by name, or nil if there is no such reader
+func Lookup(name string) Reader {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, r := range slices.Backward(readers) {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Readers returns all registered readers in the order they are tried
+func Readers() []Reader {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := slices.Clone(readers)
+	slices.Reverse(result)
+	return result
+}
+
+// HashFile returns the SHA-256 hash of the file as lowercase hex, for readers that hash the whole file
+func HashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error copying file: %w", err)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
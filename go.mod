@@ -1,4 +1,4 @@
-module taktool
+module github.com/pvarki/golang-tak-taktool
 
 go 1.24
 
@@ -0,0 +1,191 @@
+// Package apktest builds small APK files for tests.
+//
+// The manifest is given as XML text and encoded to the binary XML format of AndroidManifest.xml.
+// Attribute values that are numbers or booleans are encoded as typed values, other values as strings.
+package apktest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// Chunk types of the binary XML format
+const (
+	chunkStringPool = 0x0001
+	chunkTable      = 0x0002
+	chunkXML        = 0x0003
+	chunkStartTag   = 0x0102
+	chunkEndTag     = 0x0103
+)
+
+// Value types of the binary XML attributes
+const (
+	typeString = 0x03
+	typeInt    = 0x10
+	typeBool   = 0x12
+)
+
+// Build APK of the manifest and other files by name. Empty resources.arsc is added, so the manifest
+// can not refer to resources.
+func Build(t testing.TB, manifestXML string, files map[string][]byte) []byte {
+	t.Helper()
+	manifest, err := EncodeManifest(manifestXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resource table without packages
+	resources := chunkHeader(nil, chunkTable, 12, 12)
+	resources = binary.LittleEndian.AppendUint32(resources, 0)
+
+	entries := map[string][]byte{"AndroidManifest.xml": manifest, "resources.arsc": resources}
+	maps.Copy(entries, files)
+	return Zip(t, entries)
+}
+
+// Build zip of the files by name, e.g. an app bundle of APKs
+func Zip(t testing.TB, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write(files[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Manifest of an APK with the package name, version code and label
+func Manifest(packageName string, versionCode int, label string) string {
+	return `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="` + packageName +
+		`" android:versionCode="` + strconv.Itoa(versionCode) + `" android:versionName="1.` + strconv.Itoa(versionCode) + `">
+	<uses-sdk android:minSdkVersion="21" android:targetSdkVersion="33"/>
+	<application android:label="` + label + `"/>
+</manifest>`
+}
+
+// Encode the XML manifest to binary XML
+func EncodeManifest(manifestXML string) ([]byte, error) {
+	stringPool := []string{}
+	stringIndex := func(s string) uint32 {
+		i := slices.Index(stringPool, s)
+		if i < 0 {
+			stringPool = append(stringPool, s)
+			i = len(stringPool) - 1
+		}
+		return uint32(i)
+	}
+
+	nodes := []byte{}
+	decoder := xml.NewDecoder(strings.NewReader(manifestXML))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			attrs := []byte{}
+			count := 0
+			for _, attr := range token.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				valueType, data, raw := byte(typeString), stringIndex(attr.Value), stringIndex(attr.Value)
+				if n, err := strconv.Atoi(attr.Value); err == nil {
+					valueType, data, raw = typeInt, uint32(n), 0xFFFFFFFF
+				} else if attr.Value == "true" || attr.Value == "false" {
+					valueType, data, raw = typeBool, 0, 0xFFFFFFFF
+					if attr.Value == "true" {
+						data = 0xFFFFFFFF
+					}
+				}
+				attrs = binary.LittleEndian.AppendUint32(attrs, stringIndex(attr.Name.Space))
+				attrs = binary.LittleEndian.AppendUint32(attrs, stringIndex(attr.Name.Local))
+				attrs = binary.LittleEndian.AppendUint32(attrs, raw)
+				attrs = binary.LittleEndian.AppendUint16(attrs, 8)
+				attrs = append(attrs, 0, valueType)
+				attrs = binary.LittleEndian.AppendUint32(attrs, data)
+				count++
+			}
+
+			nodes = chunkHeader(nodes, chunkStartTag, 16, uint32(36+len(attrs)))
+			nodes = binary.LittleEndian.AppendUint32(nodes, 1)
+			nodes = binary.LittleEndian.AppendUint32(nodes, 0xFFFFFFFF)
+			nodes = binary.LittleEndian.AppendUint32(nodes, stringIndex(""))
+			nodes = binary.LittleEndian.AppendUint32(nodes, stringIndex(token.Name.Local))
+			// Attribute start and size, attribute count, and id, class and style attribute indexes
+			for _, value := range []uint16{20, 20, uint16(count), 0, 0, 0} {
+				nodes = binary.LittleEndian.AppendUint16(nodes, value)
+			}
+			nodes = append(nodes, attrs...)
+		case xml.EndElement:
+			nodes = chunkHeader(nodes, chunkEndTag, 16, 24)
+			nodes = binary.LittleEndian.AppendUint32(nodes, 1)
+			nodes = binary.LittleEndian.AppendUint32(nodes, 0xFFFFFFFF)
+			nodes = binary.LittleEndian.AppendUint32(nodes, stringIndex(""))
+			nodes = binary.LittleEndian.AppendUint32(nodes, stringIndex(token.Name.Local))
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("manifest has no elements")
+	}
+
+	// UTF-16 strings with length prefix and zero terminator
+	offsets := []byte{}
+	stringData := []byte{}
+	for _, s := range stringPool {
+		offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(stringData)))
+		units := utf16.Encode([]rune(s))
+		stringData = binary.LittleEndian.AppendUint16(stringData, uint16(len(units)))
+		for _, unit := range units {
+			stringData = binary.LittleEndian.AppendUint16(stringData, unit)
+		}
+		stringData = binary.LittleEndian.AppendUint16(stringData, 0)
+	}
+	for len(stringData)%4 != 0 {
+		stringData = append(stringData, 0)
+	}
+	stringsStart := uint32(28 + len(offsets))
+	pool := chunkHeader(nil, chunkStringPool, 28, stringsStart+uint32(len(stringData)))
+	for _, value := range []uint32{uint32(len(stringPool)), 0, 0, stringsStart, 0} {
+		pool = binary.LittleEndian.AppendUint32(pool, value)
+	}
+	pool = append(pool, offsets...)
+	pool = append(pool, stringData...)
+
+	data := chunkHeader(nil, chunkXML, 8, uint32(8+len(pool)+len(nodes)))
+	data = append(data, pool...)
+	return append(data, nodes...), nil
+}
+
+// Append chunk header of the type, header size and chunk size
+func chunkHeader(data []byte, chunkType, headerSize uint16, size uint32) []byte {
+	data = binary.LittleEndian.AppendUint16(data, chunkType)
+	data = binary.LittleEndian.AppendUint16(data, headerSize)
+	return binary.LittleEndian.AppendUint32(data, size)
+}
@@ -1,19 +1,80 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/pvarki/golang-tak-taktool/packager"
+)
+
+// Exit codes
+const (
+	exitError = 1
+	// Some files could not be read with -keep-going, the rest were packaged
+	exitPackagesSkipped = 2
 )
 
+// Version of taktool, set when building with -ldflags "-X main.version=1.2.3"
+var version = ""
+
+// Options parsed from the command line
+type options struct {
+	dontRenamePlugins bool
+	dpDeleteOnReceive bool
+	dpImportOnReceive bool
+	dpName            string
+	dpUID             string
+	dpExt             string
+	policyFile        string
+	pinsFile          string
+	abis              []string
+	json              bool
+	apkDir            string
+	iconDir           string
+	recursive         bool
+	keepGoing         bool
+	jobs              int
+	noCache           bool
+	keepSnapshots     int
+	operator          string
+	// Directories given with -C, the command is run in each of them
+	dirs   []string
+	inDir  string
+	outDir string
+	// Release channel, and source and target channels of promote
+	channel string
+	from    string
+	to      string
+	// Arguments without dash, e.g. the command
+	args []string
+}
+
 func main() {
+	packager.Version = version
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage:  %s\n\n", "taktool COMMAND [OPTIONS]")
 		// Print commands
 		fmt.Fprintf(os.Stderr, "Commands:\n")
 		fmt.Fprintf(os.Stderr, "  pluginspackage, pp\tCreate plugins package\n")
+		fmt.Fprintf(os.Stderr, "  pp audit\t\tList permissions, features, components and extensions of plugins\n")
+		fmt.Fprintf(os.Stderr, "  pp add FILE\t\tAdd plugin file to existing plugins package\n")
+		fmt.Fprintf(os.Stderr, "  pp ingest ARCHIVE\tUnpack plugins and release notes from vendor zip archive\n")
+		fmt.Fprintf(os.Stderr, "  pp pending\t\tCheck plugins in incoming directory and list them with their problems\n")
+		fmt.Fprintf(os.Stderr, "  pp approve PACKAGE@REVISION\n\t\t\tPublish plugin from incoming directory\n")
+		fmt.Fprintf(os.Stderr, "  pp reject PACKAGE@REVISION|FILE [REASON]\n\t\t\tMove plugin from incoming directory to rejected directory\n")
+		fmt.Fprintf(os.Stderr, "  pp remove PACKAGE\tRemove plugin from existing plugins package\n")
+		fmt.Fprintf(os.Stderr, "  pp pin PACKAGE REVISION\tKeep revision of plugin published and hold back newer revisions\n")
+		fmt.Fprintf(os.Stderr, "  pp unpin PACKAGE\tRemove pin of plugin\n")
+		fmt.Fprintf(os.Stderr, "  pp promote PACKAGE -from=CHANNEL -to=CHANNEL\n\t\t\tCopy plugin from one release channel to another\n")
+		fmt.Fprintf(os.Stderr, "  pp history\t\tList snapshots of plugins package\n")
+		fmt.Fprintf(os.Stderr, "  pp rollback SNAPSHOT\tRestore plugins package and plugins of snapshot\n")
+		fmt.Fprintf(os.Stderr, "  pp watch\t\tCreate plugins package again whenever plugins or images change\n")
 		fmt.Fprintf(os.Stderr, "  datapackage, dp\tCreate data package\n\n")
 		// Print options
 		fmt.Fprintf(os.Stderr, "Options:\n")
@@ -26,70 +87,396 @@ func main() {
 	flag.String("dbext", "dpk", "Set data package file extension")
 	flag.Bool("deleteonreceive", false, "Set data package \"onReceiveDelete\" to delete the package after receive")
 	flag.Bool("importonreceive", false, "Set data package \"onReceiveImport\" to import the package after receive")
-	flag.Bool("renamepluginsdisabled", false, "Disable renaming of plugins to preferred names. Renaming removes older plugins with the same name.")
+	flag.Bool("renamepluginsdisabled", false, "Disable renaming of plugins to preferred names. Renaming removes older revisions of the same plugins.")
+	flag.String("policy", packager.DefaultPolicyFilename, "Set plugin policy file, used if it exists")
+	flag.String("pins", packager.DefaultPinsFilename, "Set pin file of plugin revisions to keep published, used if it exists")
+	flag.String("abi", "", "Only package plugins compatible with these comma separated ABIs, e.g. armeabi-v7a")
+	flag.Bool("json", false, "Print pp audit, pp history and pp pending output as JSON")
+	flag.String("apkdir", "", "Set plugins package directory of APK, IPA and MSI files (default is current directory)")
+	flag.String("icondir", "", "Set plugins package directory of icons in product.infz (default is next to APK files)")
+	flag.Bool("recursive", false, "Read plugins from subdirectories of the APK directory too")
+	flag.Int("jobs", 0, "Set number of plugins read at the same time (default is number of CPUs)")
+	flag.Bool("no-cache", false, "Parse all plugins instead of only new or changed ones, metadata cache is not used or updated")
+	flag.Bool("keep-going", false, "Skip plugins that can not be read, list them in product.failures.txt and exit with code 2")
+	flag.Int("keep", 0, "Remove all but this many newest snapshots and their archived plugins with pp history")
+	flag.String("operator", "", "Set operator name in audit log (default is $TAKTOOL_OPERATOR or current user)")
+	flag.String("C", "", "Run in directory, can be given several times to process several repositories")
+	flag.String("channel", "", "Use release channel in channels/CHANNEL directory")
+	flag.String("from", "", "Set source channel of pp promote")
+	flag.String("to", "", "Set target channel of pp promote")
+	flag.String("in", "", "Set input directory (default is current directory)")
+	flag.String("out", "", "Set output directory (default is input directory)")
 
 	flag.Parse()
 
-	dontRenamePlugins, dpDeleteOnReceive, dpImportOnReceive, dpName, dpUID, dpExt := manualFlagsParse() // Flag package cant parse flags if agruments without dash is used
+	opts, err := manualFlagsParse(os.Args[1:]) // Flag package cant parse flags if agruments without dash is used
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		flag.Usage()
+		os.Exit(exitError)
+	}
 
 	// If no arguments, print usage
-	if flag.NArg() == 0 {
+	if len(opts.args) == 0 {
 		flag.Usage()
 		return
 	}
 
-	arg0 := flag.Arg(0)
+	pluginsOpts := packager.PluginsOptions{
+		RenamePlugins: !opts.dontRenamePlugins,
+		PolicyFile:    opts.policyFile,
+		PinsFile:      opts.pinsFile,
+		Abis:          opts.abis,
+		JSON:          opts.json,
+		ApkDir:        opts.apkDir,
+		IconDir:       opts.iconDir,
+		Recursive:     opts.recursive,
+		KeepGoing:     opts.keepGoing,
+		Jobs:          opts.jobs,
+		NoCache:       opts.noCache,
+		Operator:      opts.operator,
+		KeepSnapshots: opts.keepSnapshots,
+	}
+
+	dirs := opts.dirs
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	// Watch keeps watching all directories
+	if (opts.args[0] == "pluginspackage" || opts.args[0] == "pp") && argAt(opts.args, 1) == "watch" {
+		err := packager.WatchPlugins(dirs, func(dir string, do func(opts packager.PluginsOptions)) error {
+			_, err := runInDirectory(dir, opts, func(outDir string) int {
+				dirOpts := pluginsOpts
+				dirOpts.OutDir = outDir
+				do(dirOpts)
+				return 0
+			})
+			return err
+		})
+		os.Exit(commandExitCode("Error watching plugins", err))
+	}
+
+	exitCode := 0
+	for _, dir := range dirs {
+		if len(dirs) > 1 {
+			fmt.Println("Repository:", dir)
+		}
+		code, err := runInDirectory(dir, opts, func(outDir string) int {
+			pluginsOpts.OutDir = outDir
+			return runCommand(opts, pluginsOpts)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if code == exitError {
+			os.Exit(exitError)
+		}
+		exitCode = max(exitCode, code)
+	}
+	os.Exit(exitCode)
+}
+
+// Run the command and return the exit code
+func runCommand(opts options, pluginsOpts packager.PluginsOptions) int {
+	arg0 := opts.args[0]
 	switch arg0 {
 	case "pluginspackage", "pp":
-		// Handle pluginspackage command
-		err := PackagePlugins(!dontRenamePlugins)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating plugins package: %v\n", err)
-			os.Exit(1)
+		switch argAt(opts.args, 1) {
+		case "audit":
+			// Handle pluginspackage audit subcommand
+			err := packager.AuditPlugins(pluginsOpts)
+			return commandExitCode("Error auditing plugins", err)
+		case "add":
+			// Handle pluginspackage add subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.AddPlugin(opts.args[2], pluginsOpts)
+			return commandExitCode("Error adding plugin", err)
+		case "pin":
+			// Handle pluginspackage pin subcommand
+			if len(opts.args) < 4 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.PinPlugin(opts.args[2], opts.args[3], pluginsOpts)
+			return commandExitCode("Error pinning plugin", err)
+		case "unpin":
+			// Handle pluginspackage unpin subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.UnpinPlugin(opts.args[2], pluginsOpts)
+			return commandExitCode("Error unpinning plugin", err)
+		case "promote":
+			// Handle pluginspackage promote subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.PromotePlugin(opts.args[2], opts.from, opts.to, pluginsOpts)
+			return commandExitCode("Error promoting plugin", err)
+		case "history":
+			// Handle pluginspackage history subcommand
+			if pluginsOpts.KeepSnapshots > 0 {
+				err := packager.PruneSnapshots(pluginsOpts)
+				return commandExitCode("Error pruning history", err)
+			}
+			err := packager.PluginHistory(pluginsOpts)
+			return commandExitCode("Error reading history", err)
+		case "rollback":
+			// Handle pluginspackage rollback subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.RollbackPlugins(opts.args[2], pluginsOpts)
+			return commandExitCode("Error rolling back", err)
+		case "ingest":
+			// Handle pluginspackage ingest subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.IngestArchive(opts.args[2], pluginsOpts)
+			return commandExitCode("Error ingesting archive", err)
+		case "pending":
+			// Handle pluginspackage pending subcommand
+			err := packager.ListPendingPlugins(pluginsOpts)
+			return commandExitCode("Error checking incoming plugins", err)
+		case "approve":
+			// Handle pluginspackage approve subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.ApprovePlugin(opts.args[2], pluginsOpts)
+			return commandExitCode("Error approving plugin", err)
+		case "reject":
+			// Handle pluginspackage reject subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.RejectPlugin(opts.args[2], strings.Join(opts.args[3:], " "), pluginsOpts)
+			return commandExitCode("Error rejecting plugin", err)
+		case "remove":
+			// Handle pluginspackage remove subcommand
+			if len(opts.args) < 3 {
+				flag.Usage()
+				return exitError
+			}
+			err := packager.RemovePlugin(opts.args[2], pluginsOpts)
+			return commandExitCode("Error removing plugin", err)
+		default:
+			// Handle pluginspackage command
+			err := packager.PackagePlugins(pluginsOpts)
+			return commandExitCode("Error creating plugins package", err)
 		}
 	case "datapackage", "dp":
 		// Handle datapackage command
-		err := PackageDataPackage(
-			dpUID,
-			dpName,
-			dpExt,
-			dpDeleteOnReceive,
-			dpImportOnReceive,
+		err := packager.PackageDataPackage(
+			opts.dpUID,
+			opts.dpName,
+			opts.dpExt,
+			opts.dpDeleteOnReceive,
+			opts.dpImportOnReceive,
+			pluginsOpts.OutDir,
 		)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating data package: %v\n", err)
-			os.Exit(1)
-		}
+		return commandExitCode("Error creating data package", err)
 	default:
 		// Print usage if unknown command
 		flag.Usage()
 	}
+	return 0
+}
+
+// Print the error of the command and get the exit code for it
+func commandExitCode(message string, err error) int {
+	if errors.Is(err, packager.ErrPackagesSkipped) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return exitPackagesSkipped
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", message, err)
+		return exitError
+	}
+	return 0
 }
 
-func manualFlagsParse() (dontRenamePlugins, dpDeleteOnReceive, dpImportOnReceive bool, dpName, dpUID, dpExt string) {
+// Run in the directory, reading input from the input directory or its channel. Output directory is relative to the directory
+// and is passed to run as an absolute path. Working directory is restored afterwards.
+func runInDirectory(dir string, opts options, run func(outDir string) int) (int, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return 0, fmt.Errorf("error getting working directory: %w", err)
+	}
+	defer os.Chdir(wd)
 
-	// Datapackage default file extension
-	dpExt = "dpk"
+	err = os.Chdir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("error changing directory: %w", err)
+	}
 
-	for _, arg := range os.Args[1:] {
-		switch arg {
-		case "-renamepluginsdisabled":
-			dontRenamePlugins = true
-		case "-deleteonreceive":
-			dpDeleteOnReceive = true
-		case "-importonreceive":
-			dpImportOnReceive = true
-		default:
-			if strings.HasPrefix(arg, "-dpname=") {
-				dpName = strings.TrimPrefix(arg, "-dpname=")
-			} else if strings.HasPrefix(arg, "-dpuid=") {
-				dpUID = strings.TrimPrefix(arg, "-dpuid=")
-			} else if strings.HasPrefix(arg, "-dpext=") {
-				dpExt = strings.TrimPrefix(arg, "-dpext=")
+	outDir := ""
+	if opts.outDir != "" {
+		outDir, err = filepath.Abs(opts.outDir)
+		if err != nil {
+			return 0, fmt.Errorf("error resolving output directory: %w", err)
+		}
+		err = os.MkdirAll(outDir, 0755)
+		if err != nil {
+			return 0, fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+
+	if opts.inDir != "" {
+		err = os.Chdir(opts.inDir)
+		if err != nil {
+			return 0, fmt.Errorf("error changing to input directory: %w", err)
+		}
+	}
+
+	// Channel is a repository in the input directory
+	if opts.channel != "" {
+		err = os.MkdirAll(packager.ChannelDir(opts.channel), 0755)
+		if err != nil {
+			return 0, fmt.Errorf("error creating channel directory: %w", err)
+		}
+		err = os.Chdir(packager.ChannelDir(opts.channel))
+		if err != nil {
+			return 0, fmt.Errorf("error changing to channel directory: %w", err)
+		}
+	}
+
+	return run(outDir), nil
+}
+
+// Parse the number of snapshots to keep
+func parseKeep(opts *options, value string) error {
+	keep, err := strconv.Atoi(value)
+	if err != nil || keep < 1 {
+		return fmt.Errorf("invalid -keep value %q, must be a positive number", value)
+	}
+	opts.keepSnapshots = keep
+	return nil
+}
+
+// Get argument at index, or empty string
+func argAt(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func manualFlagsParse(args []string) (options, error) {
+
+	opts := options{
+		// Datapackage default file extension
+		dpExt: "dpk",
+		// Policy file is used only if it exists
+		policyFile: packager.DefaultPolicyFilename,
+		// Pin file is used only if it exists
+		pinsFile: packager.DefaultPinsFilename,
+	}
+
+	// Options without a value
+	boolOptions := map[string]*bool{
+		"renamepluginsdisabled": &opts.dontRenamePlugins,
+		"deleteonreceive":       &opts.dpDeleteOnReceive,
+		"importonreceive":       &opts.dpImportOnReceive,
+		"json":                  &opts.json,
+		"recursive":             &opts.recursive,
+		"keep-going":            &opts.keepGoing,
+		"no-cache":              &opts.noCache,
+	}
+
+	// Options with a value, given as "-name=value" or "-name value"
+	valueOptions := map[string]func(value string) error{
+		"dpname": setString(&opts.dpName),
+		"dpuid":  setString(&opts.dpUID),
+		"dpext":  setString(&opts.dpExt),
+		// Names in the usage
+		"dbname":   setString(&opts.dpName),
+		"dbuid":    setString(&opts.dpUID),
+		"dbext":    setString(&opts.dpExt),
+		"policy":   setString(&opts.policyFile),
+		"pins":     setString(&opts.pinsFile),
+		"apkdir":   setString(&opts.apkDir),
+		"icondir":  setString(&opts.iconDir),
+		"operator": setString(&opts.operator),
+		"channel":  setString(&opts.channel),
+		"from":     setString(&opts.from),
+		"to":       setString(&opts.to),
+		"in":       setString(&opts.inDir),
+		"out":      setString(&opts.outDir),
+		"abi": func(value string) error {
+			opts.abis = packager.ParseAbis(value)
+			return nil
+		},
+		"C": func(value string) error {
+			opts.dirs = append(opts.dirs, value)
+			return nil
+		},
+		"jobs": func(value string) error {
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 0 {
+				return fmt.Errorf("invalid -jobs value %q, must be a number", value)
+			}
+			opts.jobs = jobs
+			return nil
+		},
+		"keep": func(value string) error {
+			return parseKeep(&opts, value)
+		},
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			opts.args = append(opts.args, arg)
+			continue
+		}
+
+		// Options can be given with two dashes too, e.g. "--from beta"
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if target, ok := boolOptions[name]; ok {
+			if hasValue {
+				return opts, fmt.Errorf("option -%s does not take a value", name)
+			}
+			*target = true
+			continue
+		}
+		set, ok := valueOptions[name]
+		if !ok {
+			return opts, fmt.Errorf("unknown option %s", arg)
+		}
+		if !hasValue {
+			// Value is the next argument
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("option -%s requires a value", name)
 			}
+			i++
+			value = args[i]
+		}
+		err := set(value)
+		if err != nil {
+			return opts, err
 		}
 	}
 
-	return
+	return opts, nil
 }
 
+// Setter of a string option
+func setString(target *string) func(value string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/packager"
+)
+
+func TestManualFlagsParse(t *testing.T) {
+	defaults := options{dpExt: "dpk", policyFile: packager.DefaultPolicyFilename, pinsFile: packager.DefaultPinsFilename}
+	withOpts := func(set func(opts *options)) options {
+		opts := defaults
+		set(&opts)
+		return opts
+	}
+	valueOpts := withOpts(func(opts *options) {
+		opts.args = []string{"pp"}
+		opts.jobs = 4
+		opts.abis = []string{"arm64-v8a", "armeabi-v7a"}
+		opts.policyFile = "strict.json"
+		opts.pinsFile = "pins-prod.json"
+		opts.apkDir = "apks"
+		opts.iconDir = "icons"
+		opts.operator = "alice"
+		opts.dirs = []string{"repo1", "repo2"}
+		opts.channel = "beta"
+		opts.keepSnapshots = 3
+		opts.inDir = "in"
+		opts.outDir = "out"
+	})
+
+	tests := []struct {
+		name string
+		args []string
+		want options
+	}{
+		{
+			name: "values after equals sign",
+			args: []string{"pp", "-jobs=4", "-abi=arm64-v8a,armeabi-v7a", "-policy=strict.json", "-pins=pins-prod.json", "-apkdir=apks",
+				"-icondir=icons", "-operator=alice", "-C=repo1", "-C=repo2", "-channel=beta", "-keep=3", "-in=in", "-out=out"},
+			want: valueOpts,
+		},
+		{
+			name: "values as next argument",
+			args: []string{"pp", "-jobs", "4", "-abi", "arm64-v8a,armeabi-v7a", "-policy", "strict.json", "-pins", "pins-prod.json", "-apkdir", "apks",
+				"-icondir", "icons", "-operator", "alice", "-C", "repo1", "-C", "repo2", "-channel", "beta", "-keep", "3", "-in", "in", "-out", "out"},
+			want: valueOpts,
+		},
+		{
+			name: "two dashes",
+			args: []string{"pp", "promote", "com.example", "--from", "beta", "--to=stable", "--json"},
+			want: withOpts(func(opts *options) {
+				opts.args = []string{"pp", "promote", "com.example"}
+				opts.from = "beta"
+				opts.to = "stable"
+				opts.json = true
+			}),
+		},
+		{
+			name: "bool options",
+			args: []string{"-renamepluginsdisabled", "-recursive", "-keep-going", "-no-cache", "pp"},
+			want: withOpts(func(opts *options) {
+				opts.args = []string{"pp"}
+				opts.dontRenamePlugins = true
+				opts.recursive = true
+				opts.keepGoing = true
+				opts.noCache = true
+			}),
+		},
+		{
+			name: "data package",
+			args: []string{"dp", "-dpname", "mission", "-dbuid=1234", "-dpext", "zip", "-deleteonreceive", "-importonreceive"},
+			want: withOpts(func(opts *options) {
+				opts.args = []string{"dp"}
+				opts.dpName = "mission"
+				opts.dpUID = "1234"
+				opts.dpExt = "zip"
+				opts.dpDeleteOnReceive = true
+				opts.dpImportOnReceive = true
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manualFlagsParse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("manualFlagsParse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManualFlagsParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "unknown option", args: []string{"pp", "-job=4"}, want: "unknown option -job=4"},
+		{name: "unknown option with two dashes", args: []string{"pp", "--verbose"}, want: "unknown option --verbose"},
+		{name: "missing value", args: []string{"pp", "-apkdir"}, want: "option -apkdir requires a value"},
+		{name: "bool option with value", args: []string{"pp", "-json=false"}, want: "option -json does not take a value"},
+		{name: "invalid jobs", args: []string{"pp", "-jobs", "many"}, want: `invalid -jobs value "many", must be a number`},
+		{name: "invalid keep", args: []string{"pp", "history", "-keep=0"}, want: `invalid -keep value "0", must be a positive number`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manualFlagsParse(tt.args)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("manualFlagsParse() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
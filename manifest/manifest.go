@@ -0,0 +1,392 @@
+// Package manifest reads AndroidManifest.xml of an APK into a typed model.
+//
+// The binary manifest is decoded by apkparser and the tokens are collected
+// directly into the model, without serialising the manifest to XML text.
+package manifest
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/avast/apkparser"
+)
+
+// Stages of APK parsing, used in ParseError
+const (
+	StageZip       = "zip"
+	StageResources = "resources"
+	StageManifest  = "manifest"
+)
+
+// ParseError tells at which stage reading the APK failed
+type ParseError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	switch e.Stage {
+	case StageZip:
+		return "failed to open the APK: " + e.Err.Error()
+	case StageResources:
+		return "failed to parse resources: " + e.Err.Error()
+	default:
+		return "failed to parse AndroidManifest.xml: " + e.Err.Error()
+	}
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Manifest is the root <manifest> element
+type Manifest struct {
+	Package     string
+	VersionCode string
+	VersionName string
+	// Name of the split if the APK is a split APK, empty for base APKs
+	Split string
+
+	UsesSdk UsesSdk
+	// <uses-permission> and <uses-permission-sdk-23> elements
+	UsesPermissions []UsesPermission
+	// <permission> elements declared by the APK
+	Permissions  []Permission
+	UsesFeatures []UsesFeature
+	Application  Application
+}
+
+// UsesSdk is the <uses-sdk> element
+type UsesSdk struct {
+	MinSdkVersion    int
+	TargetSdkVersion int
+	MaxSdkVersion    int
+}
+
+// UsesPermission is a permission requested by the APK
+type UsesPermission struct {
+	Name          string
+	MaxSdkVersion int
+}
+
+// Permission is a permission declared by the APK
+type Permission struct {
+	Name            string
+	ProtectionLevel string
+}
+
+// UsesFeature is a hardware or software feature used by the APK
+type UsesFeature struct {
+	Name     string
+	Required bool
+}
+
+// Application is the <application> element
+type Application struct {
+	Label       string
+	Description string
+	Icon        string
+	Debuggable  bool
+	TestOnly    bool
+	// Set if the base APK can not be installed without its splits
+	IsSplitRequired bool
+
+	Activities      []Component
+	ActivityAliases []Component
+	Services        []Component
+	Receivers       []Component
+	Providers       []Component
+	MetaData        []MetaData
+}
+
+// Component is an activity, activity alias, service, receiver or provider
+type Component struct {
+	// Element name, e.g. "activity"
+	Kind       string
+	Name       string
+	Permission string
+	// Value of android:exported, nil if not set
+	Exported      *bool
+	IntentFilters []IntentFilter
+	MetaData      []MetaData
+}
+
+// IntentFilter is an <intent-filter> of a component
+type IntentFilter struct {
+	Actions    []string
+	Categories []string
+}
+
+// MetaData is a <meta-data> element
+type MetaData struct {
+	Name     string
+	Value    string
+	Resource string
+}
+
+// IsExported tells if other apps can start the component. Components are exported if
+// android:exported is set, or before Android 12 implicitly when they have an intent filter.
+func (c Component) IsExported() bool {
+	if c.Exported != nil {
+		return *c.Exported
+	}
+	return len(c.IntentFilters) > 0
+}
+
+// RequiresSplits tells if the APK can not be installed without its split APKs
+func (m Manifest) RequiresSplits() bool {
+	if m.Application.IsSplitRequired {
+		return true
+	}
+	value, _ := m.Application.MetaDataValue("com.android.vending.splits.required")
+	return value == "true"
+}
+
+// Components returns all components of the application in manifest order by kind
+func (a Application) Components() []Component {
+	components := []Component{}
+	components = append(components, a.Activities...)
+	components = append(components, a.ActivityAliases...)
+	components = append(components, a.Services...)
+	components = append(components, a.Receivers...)
+	components = append(components, a.Providers...)
+	return components
+}
+
+// MetaDataValue returns the value of application meta-data by name
+func (a Application) MetaDataValue(name string) (string, bool) {
+	for _, metaData := range a.MetaData {
+		if metaData.Name == name {
+			return metaData.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseApk reads the manifest of the APK file
+func ParseApk(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &ParseError{Stage: StageZip, Err: err}
+	}
+	defer f.Close()
+
+	return ParseApkReader(f)
+}
+
+// ParseApkReader reads the manifest of the APK from reader
+func ParseApkReader(r io.ReadSeeker) (*Manifest, error) {
+	zip, err := apkparser.OpenZipReader(r)
+	if err != nil {
+		return nil, &ParseError{Stage: StageZip, Err: err}
+	}
+	defer zip.Close()
+
+	return ParseZip(zip)
+}
+
+// ParseZip reads the manifest of the APK from an already opened zip. The zip is not closed.
+func ParseZip(zip *apkparser.ZipReader) (*Manifest, error) {
+	decoder := NewDecoder()
+
+	resErr, manErr := apkparser.ParseApkWithZip(zip, decoder)
+	if resErr != nil {
+		return nil, &ParseError{Stage: StageResources, Err: resErr}
+	}
+	if manErr != nil {
+		return nil, &ParseError{Stage: StageManifest, Err: manErr}
+	}
+
+	return decoder.Manifest(), nil
+}
+
+// Decoder collects manifest tokens into Manifest. It implements apkparser.ManifestEncoder,
+// so it can be used with any of the apkparser parse functions.
+type Decoder struct {
+	manifest Manifest
+	// Open elements
+	stack []string
+	// Component and intent filter currently being read
+	component    *Component
+	intentFilter *IntentFilter
+}
+
+// NewDecoder returns a decoder for a single manifest
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Manifest returns the manifest decoded so far
+func (d *Decoder) Manifest() *Manifest {
+	m := d.manifest
+	return &m
+}
+
+// EncodeToken handles a single token of the manifest
+func (d *Decoder) EncodeToken(t xml.Token) error {
+	switch se := t.(type) {
+	case xml.StartElement:
+		d.startElement(se)
+		d.stack = append(d.stack, se.Name.Local)
+	case xml.EndElement:
+		if len(d.stack) == 0 {
+			return errors.New("unexpected end element " + se.Name.Local)
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+		d.endElement(se)
+	}
+	return nil
+}
+
+// Flush does nothing, the manifest is available right after the last token
+func (d *Decoder) Flush() error {
+	return nil
+}
+
+// Name of the parent element of the element being started
+func (d *Decoder) parent() string {
+	if len(d.stack) == 0 {
+		return ""
+	}
+	return d.stack[len(d.stack)-1]
+}
+
+func (d *Decoder) startElement(se xml.StartElement) {
+	m := &d.manifest
+	app := &m.Application
+
+	switch se.Name.Local {
+	case "manifest":
+		m.Package = attr(se, "package")
+		m.VersionCode = attr(se, "versionCode")
+		m.VersionName = attr(se, "versionName")
+		m.Split = attr(se, "split")
+	case "uses-sdk":
+		m.UsesSdk = UsesSdk{
+			MinSdkVersion:    attrInt(se, "minSdkVersion"),
+			TargetSdkVersion: attrInt(se, "targetSdkVersion"),
+			MaxSdkVersion:    attrInt(se, "maxSdkVersion"),
+		}
+		// Target SDK defaults to min SDK if not set
+		if m.UsesSdk.TargetSdkVersion == 0 {
+			m.UsesSdk.TargetSdkVersion = m.UsesSdk.MinSdkVersion
+		}
+	case "uses-permission", "uses-permission-sdk-23":
+		if name := attr(se, "name"); name != "" {
+			m.UsesPermissions = append(m.UsesPermissions, UsesPermission{
+				Name:          name,
+				MaxSdkVersion: attrInt(se, "maxSdkVersion"),
+			})
+		}
+	case "permission":
+		if d.parent() == "manifest" {
+			m.Permissions = append(m.Permissions, Permission{
+				Name:            attr(se, "name"),
+				ProtectionLevel: attr(se, "protectionLevel"),
+			})
+		}
+	case "uses-feature":
+		if name := attr(se, "name"); name != "" {
+			m.UsesFeatures = append(m.UsesFeatures, UsesFeature{
+				Name:     name,
+				Required: attr(se, "required") != "false",
+			})
+		}
+	case "application":
+		app.Label = attr(se, "label")
+		app.Description = attr(se, "description")
+		app.Icon = attr(se, "icon")
+		app.Debuggable = attr(se, "debuggable") == "true"
+		app.TestOnly = attr(se, "testOnly") == "true"
+		app.IsSplitRequired = attr(se, "isSplitRequired") == "true"
+	case "activity", "activity-alias", "service", "receiver", "provider":
+		d.component = &Component{
+			Kind:       se.Name.Local,
+			Name:       attr(se, "name"),
+			Permission: attr(se, "permission"),
+		}
+		if exported := attr(se, "exported"); exported != "" {
+			value := exported == "true"
+			d.component.Exported = &value
+		}
+	case "intent-filter":
+		if d.component != nil {
+			d.intentFilter = &IntentFilter{}
+		}
+	case "action":
+		if d.intentFilter != nil {
+			d.intentFilter.Actions = append(d.intentFilter.Actions, attr(se, "name"))
+		}
+	case "category":
+		if d.intentFilter != nil {
+			d.intentFilter.Categories = append(d.intentFilter.Categories, attr(se, "name"))
+		}
+	case "meta-data":
+		metaData := MetaData{
+			Name:     attr(se, "name"),
+			Value:    attr(se, "value"),
+			Resource: attr(se, "resource"),
+		}
+		if d.component != nil {
+			d.component.MetaData = append(d.component.MetaData, metaData)
+		} else if d.parent() == "application" {
+			app.MetaData = append(app.MetaData, metaData)
+		}
+	}
+}
+
+func (d *Decoder) endElement(se xml.EndElement) {
+	app := &d.manifest.Application
+
+	switch se.Name.Local {
+	case "intent-filter":
+		if d.component != nil && d.intentFilter != nil {
+			d.component.IntentFilters = append(d.component.IntentFilters, *d.intentFilter)
+		}
+		d.intentFilter = nil
+	case "activity":
+		app.Activities = d.appendComponent(app.Activities)
+	case "activity-alias":
+		app.ActivityAliases = d.appendComponent(app.ActivityAliases)
+	case "service":
+		app.Services = d.appendComponent(app.Services)
+	case "receiver":
+		app.Receivers = d.appendComponent(app.Receivers)
+	case "provider":
+		app.Providers = d.appendComponent(app.Providers)
+	}
+}
+
+// Append the component being read to components and finish it
+func (d *Decoder) appendComponent(components []Component) []Component {
+	if d.component == nil {
+		return components
+	}
+	components = append(components, *d.component)
+	d.component = nil
+	return components
+}
+
+// Get value of the attribute by its local name
+func attr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Get integer value of the attribute, 0 if not set or not a number
+func attrInt(se xml.StartElement, name string) int {
+	value, err := strconv.Atoi(attr(se, name))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+var _ apkparser.ManifestEncoder = (*Decoder)(nil)
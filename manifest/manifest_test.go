@@ -0,0 +1,168 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/internal/apktest"
+)
+
+// Manifest of the fixture plugin APK
+const fixtureManifest = `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example.plugin"
+	android:versionCode="42" android:versionName="1.4.2">
+	<uses-sdk android:minSdkVersion="21" android:targetSdkVersion="33"/>
+	<uses-permission android:name="android.permission.CAMERA"/>
+	<uses-permission android:name="android.permission.READ_EXTERNAL_STORAGE" android:maxSdkVersion="32"/>
+	<uses-permission-sdk-23 android:name="android.permission.ACCESS_FINE_LOCATION"/>
+	<permission android:name="com.example.plugin.ACCESS" android:protectionLevel="signature"/>
+	<uses-feature android:name="android.hardware.camera" android:required="false"/>
+	<uses-feature android:name="android.hardware.location.gps"/>
+	<application android:label="Example" android:description="Example plugin" android:debuggable="true">
+		<meta-data android:name="plugin-api" android:value="com.atakmap.app@5.1.0.CIV"/>
+		<activity android:name=".MainActivity" android:exported="false"/>
+		<activity-alias android:name=".Launcher" android:exported="true"/>
+		<service android:name=".PluginService">
+			<intent-filter>
+				<action android:name="com.atakmap.app.component"/>
+				<category android:name="android.intent.category.DEFAULT"/>
+			</intent-filter>
+			<meta-data android:name="com.example.config" android:value="on"/>
+		</service>
+		<receiver android:name=".Receiver" android:permission="com.example.plugin.ACCESS"/>
+		<provider android:name=".Provider" android:exported="true"/>
+	</application>
+</manifest>`
+
+// Plugin descriptor of the fixture plugin APK
+const fixturePluginXML = `<?xml version="1.0" encoding="utf-8"?>
+<plugin>
+	<extension type="gov.tak.api.plugin.IPlugin" impl="com.example.plugin.Plugin" singleton="true"/>
+	<extension type="transapps.maps.plugin.tool.Tool" impl="com.example.plugin.Tool"/>
+</plugin>`
+
+// Write the fixture plugin APK, with or without plugin.xml
+func writeFixtureApk(t *testing.T, withPluginXML bool) string {
+	t.Helper()
+	files := map[string][]byte{}
+	if withPluginXML {
+		files[PluginDescriptorPath] = []byte(fixturePluginXML)
+	}
+	apkPath := filepath.Join(t.TempDir(), "plugin.apk")
+	err := os.WriteFile(apkPath, apktest.Build(t, fixtureManifest, files), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apkPath
+}
+
+func TestParseApk(t *testing.T) {
+	m, err := ParseApk(writeFixtureApk(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Package != "com.example.plugin" || m.VersionCode != "42" || m.VersionName != "1.4.2" || m.Split != "" {
+		t.Errorf("package = %s %s %s split %q, want com.example.plugin 42 1.4.2", m.Package, m.VersionCode, m.VersionName, m.Split)
+	}
+	if m.UsesSdk != (UsesSdk{MinSdkVersion: 21, TargetSdkVersion: 33}) {
+		t.Errorf("UsesSdk = %+v", m.UsesSdk)
+	}
+
+	wantUsesPermissions := []UsesPermission{
+		{Name: "android.permission.CAMERA"},
+		{Name: "android.permission.READ_EXTERNAL_STORAGE", MaxSdkVersion: 32},
+		{Name: "android.permission.ACCESS_FINE_LOCATION"},
+	}
+	if !reflect.DeepEqual(m.UsesPermissions, wantUsesPermissions) {
+		t.Errorf("UsesPermissions = %+v, want %+v", m.UsesPermissions, wantUsesPermissions)
+	}
+	wantPermissions := []Permission{{Name: "com.example.plugin.ACCESS", ProtectionLevel: "signature"}}
+	if !reflect.DeepEqual(m.Permissions, wantPermissions) {
+		t.Errorf("Permissions = %+v, want %+v", m.Permissions, wantPermissions)
+	}
+	wantFeatures := []UsesFeature{
+		{Name: "android.hardware.camera", Required: false},
+		{Name: "android.hardware.location.gps", Required: true},
+	}
+	if !reflect.DeepEqual(m.UsesFeatures, wantFeatures) {
+		t.Errorf("UsesFeatures = %+v, want %+v", m.UsesFeatures, wantFeatures)
+	}
+
+	app := m.Application
+	if app.Label != "Example" || app.Description != "Example plugin" || !app.Debuggable || app.TestOnly || m.RequiresSplits() {
+		t.Errorf("Application = %+v", app)
+	}
+	if value, ok := app.MetaDataValue("plugin-api"); !ok || value != "com.atakmap.app@5.1.0.CIV" {
+		t.Errorf("plugin-api meta-data = %q, %v", value, ok)
+	}
+	if _, ok := app.MetaDataValue("com.example.config"); ok {
+		t.Error("meta-data of a component is in application meta-data")
+	}
+
+	// Components in manifest order by kind, with their exported state
+	wantComponents := []struct {
+		kind     string
+		name     string
+		exported bool
+	}{
+		{"activity", ".MainActivity", false},
+		{"activity-alias", ".Launcher", true},
+		{"service", ".PluginService", true},
+		{"receiver", ".Receiver", false},
+		{"provider", ".Provider", true},
+	}
+	components := app.Components()
+	if len(components) != len(wantComponents) {
+		t.Fatalf("got %d components, want %d", len(components), len(wantComponents))
+	}
+	for i, want := range wantComponents {
+		got := components[i]
+		if got.Kind != want.kind || got.Name != want.name || got.IsExported() != want.exported {
+			t.Errorf("component %d = %s %s exported %v, want %s %s exported %v", i, got.Kind, got.Name, got.IsExported(), want.kind, want.name, want.exported)
+		}
+	}
+	wantFilters := []IntentFilter{{Actions: []string{"com.atakmap.app.component"}, Categories: []string{"android.intent.category.DEFAULT"}}}
+	if service := app.Services[0]; !reflect.DeepEqual(service.IntentFilters, wantFilters) || len(service.MetaData) != 1 {
+		t.Errorf("service = %+v, want intent filters %+v and one meta-data", service, wantFilters)
+	}
+	if receiver := app.Receivers[0]; receiver.Permission != "com.example.plugin.ACCESS" {
+		t.Errorf("receiver permission = %q", receiver.Permission)
+	}
+}
+
+func TestParseApkErrors(t *testing.T) {
+	_, err := ParseApk(filepath.Join(t.TempDir(), "missing.apk"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Stage != StageZip {
+		t.Errorf("ParseApk() error = %v, want zip stage error", err)
+	}
+
+	_, err = ParseApkReader(bytes.NewReader([]byte("not a zip")))
+	if !errors.As(err, &parseErr) {
+		t.Errorf("ParseApkReader() error = %v, want ParseError", err)
+	}
+}
+
+func TestReadPluginDescriptor(t *testing.T) {
+	descriptor, err := ReadPluginDescriptor(writeFixtureApk(t, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PluginDescriptor{Extensions: []PluginExtension{
+		{Type: "gov.tak.api.plugin.IPlugin", Impl: "com.example.plugin.Plugin", Singleton: true},
+		{Type: "transapps.maps.plugin.tool.Tool", Impl: "com.example.plugin.Tool"},
+	}}
+	if !reflect.DeepEqual(descriptor, want) {
+		t.Errorf("ReadPluginDescriptor() = %+v, want %+v", descriptor, want)
+	}
+
+	// APK without plugin.xml is not a plugin
+	descriptor, err = ReadPluginDescriptor(writeFixtureApk(t, false))
+	if err != nil || descriptor != nil {
+		t.Errorf("ReadPluginDescriptor() without plugin.xml = %+v, %v, want nil", descriptor, err)
+	}
+}
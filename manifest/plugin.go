@@ -0,0 +1,73 @@
+package manifest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/avast/apkparser"
+)
+
+// Path of the ATAK plugin descriptor in the APK
+const PluginDescriptorPath = "assets/plugin.xml"
+
+// PluginDescriptor is the ATAK plugin descriptor from assets/plugin.xml
+type PluginDescriptor struct {
+	Extensions []PluginExtension `xml:"extension"`
+}
+
+// PluginExtension is a lifecycle, tool or other extension declared by the plugin
+type PluginExtension struct {
+	// Extension interface, e.g. "transapps.maps.plugin.lifecycle.Lifecycle" or "gov.tak.api.plugin.IPlugin"
+	Type string `xml:"type,attr"`
+	// Implementation class
+	Impl      string `xml:"impl,attr"`
+	Singleton bool   `xml:"singleton,attr"`
+}
+
+// ParsePluginDescriptor reads the plain text plugin.xml
+func ParsePluginDescriptor(r io.Reader) (*PluginDescriptor, error) {
+	descriptor := PluginDescriptor{}
+	if err := xml.NewDecoder(r).Decode(&descriptor); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", PluginDescriptorPath, err)
+	}
+	return &descriptor, nil
+}
+
+// ReadPluginDescriptor reads the plugin descriptor of the APK file.
+// Returns nil without error if the APK has no plugin descriptor.
+func ReadPluginDescriptor(path string) (*PluginDescriptor, error) {
+	zip, err := apkparser.OpenZip(path)
+	if err != nil {
+		return nil, &ParseError{Stage: StageZip, Err: err}
+	}
+	defer zip.Close()
+
+	return ParsePluginDescriptorZip(zip)
+}
+
+// ParsePluginDescriptorZip reads the plugin descriptor from an already opened APK zip.
+// Returns nil without error if the APK has no plugin descriptor. The zip is not closed.
+func ParsePluginDescriptorZip(zip *apkparser.ZipReader) (*PluginDescriptor, error) {
+	file := zip.File[PluginDescriptorPath]
+	if file == nil {
+		return nil, nil
+	}
+
+	if err := file.Open(); err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", PluginDescriptorPath, err)
+	}
+	defer file.Close()
+
+	// Zip entries may have several headers, use the first one that can be read
+	var lastErr error
+	for file.Next() {
+		descriptor, err := ParsePluginDescriptor(file)
+		if err == nil {
+			return descriptor, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,83 @@
+package packager
+
+import (
+	"archive/zip"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Read native libraries in apk from lib/<abi>/ entries, and the ABIs they are built for
+func readNativeLibraries(zipReader *zip.Reader) (abis []string, libraries []string) {
+	abis = []string{}
+	libraries = []string{}
+	for _, zipFile := range zipReader.File {
+		// Only shared libraries directly under lib/<abi>/ are loaded by Android
+		parts := strings.Split(zipFile.Name, "/")
+		if len(parts) != 3 || parts[0] != "lib" || !strings.HasSuffix(parts[2], ".so") {
+			continue
+		}
+		libraries = append(libraries, zipFile.Name)
+		if !slices.Contains(abis, parts[1]) {
+			abis = append(abis, parts[1])
+		}
+	}
+	slices.Sort(abis)
+	slices.Sort(libraries)
+
+	return abis, libraries
+}
+
+// Older ABIs that devices of an ABI can run too
+var compatibleAbis = map[string][]string{
+	"armeabi-v7a": {"armeabi"},
+}
+
+// Parse comma separated ABIs, e.g. "arm64-v8a, armeabi-v7a"
+func ParseAbis(value string) []string {
+	abis := []string{}
+	for _, abi := range strings.Split(value, ",") {
+		abi = strings.TrimSpace(abi)
+		if abi != "" && !slices.Contains(abis, abi) {
+			abis = append(abis, abi)
+		}
+	}
+	return abis
+}
+
+// Check if apk can be installed on a device supporting one of the ABIs.
+// Apks without native libraries are compatible with every device.
+func isAbiCompatible(apkInfo ApkInfo, abis []string) bool {
+	if len(apkInfo.Abis) == 0 {
+		return true
+	}
+	for _, abi := range apkInfo.Abis {
+		for _, deviceAbi := range abis {
+			if abi == deviceAbi || slices.Contains(compatibleAbis[deviceAbi], abi) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return only apks compatible with one of the ABIs
+func filterApkInfosByAbi(apkInfos []ApkInfo, abis []string) []ApkInfo {
+	compatible := []ApkInfo{}
+	for _, apkInfo := range apkInfos {
+		if !isAbiCompatible(apkInfo, abis) {
+			fmt.Println("Skipping package", apkInfo.DisplayName, ": native libraries only for", strings.Join(apkInfo.Abis, ", "))
+			continue
+		}
+		compatible = append(compatible, apkInfo)
+	}
+	return compatible
+}
+
+// Format ABIs for listings
+func formatAbis(abis []string) string {
+	if len(abis) == 0 {
+		return "any (no native libraries)"
+	}
+	return strings.Join(abis, ", ")
+}
@@ -0,0 +1,143 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Plugin in the audit with its pin state. Revision the package is pinned to, and
+// if the package is a newer revision held back because of the pin.
+type auditedPlugin struct {
+	ApkInfo
+	PinnedRevision string
+	Held           bool
+}
+
+// Print permissions, features, exported components and plugin extensions of every plugin in the apk directory,
+// including held plugins and pins.
+// With JSON option, all parsed apk information is printed as JSON.
+func AuditPlugins(opts PluginsOptions) error {
+	policy, err := loadPolicy(opts.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("error loading policy: %w", err)
+	}
+
+	pins, err := loadPins(opts.PinsFile)
+	if err != nil {
+		return err
+	}
+
+	// Bundles are extracted to a temporary directory, audit does not change the plugins directory
+	extractDir, removeExtractDir, err := createExtractDir()
+	if err != nil {
+		return err
+	}
+	defer removeExtractDir()
+
+	apkInfos, failures, err := readApkInfos(opts, extractDir)
+	if err != nil {
+		return err
+	}
+
+	// Held plugins are listed too
+	heldInfos, heldFailures, err := readHeldApkInfos(opts, extractDir)
+	if err != nil {
+		return err
+	}
+	failures = append(failures, heldFailures...)
+	heldPaths := map[string]bool{}
+	for _, heldInfo := range heldInfos {
+		heldPaths[heldInfo.ApkPath] = true
+	}
+
+	plugins := []auditedPlugin{}
+	for _, apkInfo := range sortApkInfos(append(apkInfos, heldInfos...)) {
+		plugins = append(plugins, auditedPlugin{ApkInfo: apkInfo, PinnedRevision: pins[apkInfo.Package], Held: heldPaths[apkInfo.ApkPath]})
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(plugins, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		if len(failures) > 0 {
+			return fmt.Errorf("%w: %d files", ErrPackagesSkipped, len(failures))
+		}
+		return nil
+	}
+
+	fmt.Print(createAuditReport(plugins, policy))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %d files", ErrPackagesSkipped, len(failures))
+	}
+
+	return nil
+}
+
+// Create audit report text for the apks
+func createAuditReport(plugins []auditedPlugin, policy Policy) string {
+	var report strings.Builder
+
+	for _, plugin := range plugins {
+		apkInfo := plugin.ApkInfo
+		fmt.Fprintf(&report, "%s (%s %s, revision %s)\n", apkInfo.DisplayName, apkInfo.Package, apkInfo.Version, apkInfo.Revision)
+		fmt.Fprintf(&report, "  File: %s\n", apkInfo.ApkPath)
+		if plugin.Held && plugin.PinnedRevision == "" {
+			fmt.Fprintf(&report, "  Held back: no longer pinned, released when plugins are packaged\n")
+		} else if plugin.Held {
+			fmt.Fprintf(&report, "  Held back: pinned to revision %s\n", plugin.PinnedRevision)
+		} else if plugin.PinnedRevision != "" {
+			fmt.Fprintf(&report, "  Pinned: revision %s\n", plugin.PinnedRevision)
+		}
+		if apkInfo.Platform == "Android" {
+			fmt.Fprintf(&report, "  SDK: min %d, target %d\n", apkInfo.MinSdk, apkInfo.TargetSdk)
+			fmt.Fprintf(&report, "  Debuggable: %t, test-only: %t\n", apkInfo.Debuggable, apkInfo.TestOnly)
+			fmt.Fprintf(&report, "  ABIs: %s\n", formatAbis(apkInfo.Abis))
+		}
+
+		fmt.Fprintf(&report, "  Plugin extensions:\n")
+		for _, extension := range apkInfo.Extensions {
+			fmt.Fprintf(&report, "    %s: %s\n", extension.Type, extension.Impl)
+		}
+
+		fmt.Fprintf(&report, "  Permissions:\n")
+		for _, permission := range apkInfo.Permissions {
+			if policy.isPermissionDenied(permission) {
+				permission += " [DENIED]"
+			}
+			fmt.Fprintf(&report, "    %s\n", permission)
+		}
+
+		fmt.Fprintf(&report, "  Features:\n")
+		for _, feature := range apkInfo.Features {
+			fmt.Fprintf(&report, "    %s\n", feature)
+		}
+
+		fmt.Fprintf(&report, "  Exported components:\n")
+		for _, component := range apkInfo.ExportedComponents {
+			fmt.Fprintf(&report, "    %s\n", component)
+		}
+
+		// Summary of policy check
+		violations := policy.Check(apkInfo)
+		denied := false
+		for _, violation := range violations {
+			if violation.Fatal {
+				denied = true
+				fmt.Fprintf(&report, "  Policy violation: %s\n", violation.Reason)
+			} else {
+				fmt.Fprintf(&report, "  Warning: %s\n", violation.Reason)
+			}
+		}
+		if denied {
+			fmt.Fprintf(&report, "  Policy: DENIED\n\n")
+		} else {
+			fmt.Fprintf(&report, "  Policy: OK\n\n")
+		}
+	}
+
+	return report.String()
+}
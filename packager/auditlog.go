@@ -0,0 +1,198 @@
+package packager
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// Audit log of the output directory, one JSON object per line for every package change
+const auditLogFilename = "product.audit.jsonl"
+
+// Environment variable of the operator name, used if -operator is not given
+const operatorEnv = "TAKTOOL_OPERATOR"
+
+// Version of taktool in the audit log, empty uses the module version or commit taktool was built from
+var Version = ""
+
+const (
+	auditAdded    = "added"
+	auditRemoved  = "removed"
+	auditRenamed  = "renamed"
+	auditReplaced = "replaced"
+)
+
+// Package change in the audit log. Previous fields are set for renamed and replaced packages.
+type auditEntry struct {
+	Time             time.Time `json:"time"`
+	Operator         string    `json:"operator"`
+	TaktoolVersion   string    `json:"taktoolVersion"`
+	Action           string    `json:"action"`
+	Package          string    `json:"package"`
+	Platform         string    `json:"platform"`
+	Revision         string    `json:"revision"`
+	Path             string    `json:"path"`
+	Hash             string    `json:"hash"`
+	PreviousRevision string    `json:"previousRevision,omitempty"`
+	PreviousPath     string    `json:"previousPath,omitempty"`
+	PreviousHash     string    `json:"previousHash,omitempty"`
+}
+
+// Create an audit log entry of the package
+func newAuditEntry(action string, apkInfo ApkInfo) auditEntry {
+	return auditEntry{
+		Action:   action,
+		Package:  apkInfo.Package,
+		Platform: apkInfo.Platform,
+		Revision: apkInfo.Revision,
+		Path:     apkInfo.ApkPath,
+		Hash:     apkInfo.Hash,
+	}
+}
+
+// Create an audit log entry of the renamed package file
+func newRenameEntry(apkInfo ApkInfo, newPath string) auditEntry {
+	entry := newAuditEntry(auditRenamed, apkInfo)
+	entry.Path = newPath
+	entry.PreviousRevision = apkInfo.Revision
+	entry.PreviousPath = apkInfo.ApkPath
+	entry.PreviousHash = apkInfo.Hash
+	return entry
+}
+
+// Record a change of a package file in the transaction, written to the audit log with product.infz
+func (tx *transaction) logChange(entry auditEntry) {
+	tx.changes = append(tx.changes, entry)
+}
+
+// Append the package file changes recorded in the transaction and the changes between the previous and
+// the new product.infz to the audit log in the transaction. Every change is logged once, removal of
+// a published revision is logged as replaced by the new revision. Nothing is written if nothing changed.
+func writeAuditLog(tx *transaction, apkInfos []ApkInfo, outDir string, opts PluginsOptions) error {
+	previousInfos, err := readProductInfz(filepath.Join(outDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+
+	entries := []auditEntry{}
+	for _, change := range tx.changes {
+		if change.Action == auditRemoved && isReplacedRevision(change, previousInfos, apkInfos) {
+			continue
+		}
+		entries = append(entries, change)
+	}
+	for _, apkInfo := range sortApkInfos(apkInfos) {
+		found := false
+		for _, previous := range previousInfos {
+			if previous.Package != apkInfo.Package || previous.Platform != apkInfo.Platform {
+				continue
+			}
+			found = true
+
+			action := auditReplaced
+			if previous.Hash == apkInfo.Hash {
+				if previous.ApkPath == apkInfo.ApkPath || isLoggedRename(tx.changes, apkInfo) {
+					continue
+				}
+				action = auditRenamed
+			}
+			entry := newAuditEntry(action, apkInfo)
+			entry.PreviousRevision = previous.Revision
+			entry.PreviousPath = previous.ApkPath
+			entry.PreviousHash = previous.Hash
+			entries = append(entries, entry)
+		}
+		if !found {
+			entries = append(entries, newAuditEntry(auditAdded, apkInfo))
+		}
+	}
+	for _, previous := range previousInfos {
+		if !slices.ContainsFunc(apkInfos, func(apkInfo ApkInfo) bool {
+			return apkInfo.Package == previous.Package && apkInfo.Platform == previous.Platform
+		}) {
+			entries = append(entries, newAuditEntry(auditRemoved, previous))
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	operator := auditOperator(opts)
+	taktoolVersion := taktoolVersion()
+	var log bytes.Buffer
+	encoder := json.NewEncoder(&log)
+	for _, entry := range entries {
+		entry.Time = now
+		entry.Operator = operator
+		entry.TaktoolVersion = taktoolVersion
+		err = encoder.Encode(entry)
+		if err != nil {
+			return fmt.Errorf("error encoding audit log: %w", err)
+		}
+	}
+
+	err = tx.appendFile(filepath.Join(outDir, auditLogFilename), log.Bytes())
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return nil
+}
+
+// Check if the removed file is the published revision of a package that stays published with another revision
+func isReplacedRevision(change auditEntry, previousInfos, apkInfos []ApkInfo) bool {
+	published := slices.ContainsFunc(previousInfos, func(previous ApkInfo) bool {
+		return previous.ApkPath == change.Path && previous.Hash == change.Hash && previous.Platform == change.Platform
+	})
+	return published && slices.ContainsFunc(apkInfos, func(apkInfo ApkInfo) bool {
+		return apkInfo.Package == change.Package && apkInfo.Platform == change.Platform
+	})
+}
+
+// Check if the rename of the file to its published path was already recorded
+func isLoggedRename(changes []auditEntry, apkInfo ApkInfo) bool {
+	return slices.ContainsFunc(changes, func(change auditEntry) bool {
+		return change.Action == auditRenamed && change.Hash == apkInfo.Hash && change.Path == apkInfo.ApkPath
+	})
+}
+
+// Name of the operator from -operator, TAKTOOL_OPERATOR or the user running taktool
+func auditOperator(opts PluginsOptions) string {
+	operator := cmp.Or(opts.Operator, os.Getenv(operatorEnv))
+	if operator != "" {
+		return operator
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return currentUser.Username
+}
+
+// Version of taktool from the build, or the commit it was built from
+func taktoolVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return "devel-" + setting.Value[:min(len(setting.Value), 12)]
+		}
+	}
+	return "devel"
+}
@@ -0,0 +1,115 @@
+package packager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+// Read the audit log entries as "action path previousPath" lines
+func readAuditLogLines(t *testing.T, logPath string) []string {
+	t.Helper()
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := auditEntry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", entry.Action, entry.Path, entry.PreviousPath))
+	}
+	return lines
+}
+
+// Remove older revisions, rename the files and write the index in a transaction like packaging does
+func packageTestPlugins(t *testing.T, apkInfos []ApkInfo) {
+	t.Helper()
+	for _, apkInfo := range apkInfos {
+		if _, err := os.Stat(apkInfo.ApkPath); err == nil {
+			continue
+		}
+		writeTestFiles(t, ".", map[string]string{apkInfo.ApkPath: apkInfo.Hash})
+	}
+
+	err := inTransaction(".", func(tx *transaction) error {
+		apkInfos, err := RemoveOlderPluginVersions(tx, apkInfos)
+		if err != nil {
+			return err
+		}
+		apkInfos, err = RenamePlugins(tx, apkInfos)
+		if err != nil {
+			return err
+		}
+		return writeIndex(tx, apkInfos, ".", PluginsOptions{Operator: "tester"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogFileChanges(t *testing.T) {
+	t.Chdir(t.TempDir())
+	pluginA := ApkInfo{Package: "com.a", Platform: "Android", DisplayName: "A", Type: "plugin", IconData: []byte{}}
+	appB := ApkInfo{Package: "com.b", Platform: "iOS", DisplayName: "B", Type: "app", IconData: []byte{}}
+	withFile := func(apkInfo ApkInfo, revision, apkPath, hash string) ApkInfo {
+		apkInfo.Revision = revision
+		apkInfo.ApkPath = apkPath
+		apkInfo.Hash = hash
+		return apkInfo
+	}
+
+	// Older revision and duplicate are removed, the rest are renamed and published
+	packageTestPlugins(t, []ApkInfo{
+		withFile(pluginA, "1", "old.apk", "h1"),
+		withFile(pluginA, "2", "new.apk", "h2"),
+		withFile(appB, "1", "x.ipa", "h3"),
+		withFile(appB, "1", "hidden.ipa", "h3"),
+	})
+	want := []string{
+		"removed old.apk ",
+		"removed hidden.ipa ",
+		"renamed a_plugin.apk new.apk",
+		"renamed b_app.ipa x.ipa",
+		"added a_plugin.apk ",
+		"added b_app.ipa ",
+	}
+	got := readAuditLogLines(t, auditLogFilename)
+	if !slices.Equal(got, want) {
+		t.Errorf("audit log after first packaging = %q, want %q", got, want)
+	}
+
+	// Removal of the published revision is logged as replaced by the new revision
+	packageTestPlugins(t, []ApkInfo{
+		withFile(pluginA, "2", "a_plugin.apk", "h2"),
+		withFile(pluginA, "3", "newer.apk", "h4"),
+		withFile(appB, "1", "b_app.ipa", "h3"),
+	})
+	want = append(want,
+		"renamed a_plugin.apk newer.apk",
+		"replaced a_plugin.apk a_plugin.apk",
+	)
+	got = readAuditLogLines(t, auditLogFilename)
+	if !slices.Equal(got, want) {
+		t.Errorf("audit log after second packaging = %q, want %q", got, want)
+	}
+
+	// Nothing is logged if nothing changed
+	packageTestPlugins(t, []ApkInfo{
+		withFile(pluginA, "3", "a_plugin.apk", "h4"),
+		withFile(appB, "1", "b_app.ipa", "h3"),
+	})
+	got = readAuditLogLines(t, auditLogFilename)
+	if !slices.Equal(got, want) {
+		t.Errorf("audit log after unchanged packaging = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,271 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pvarki/golang-tak-taktool/manifest"
+)
+
+// Check if the file is an apk file
+func isApkFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".apk")
+}
+
+// Check if the file is an app bundle output (.apks) or XAPK containing several apks
+func isBundleFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".apks" || ext == ".xapk"
+}
+
+// Extract the installable apk from the bundle to the extract directory. Returns the path of the apk next to
+// the bundle, which is also the path of the apk relative to the extract directory. If an apk with the same
+// content is already in apkInfos, nothing is extracted and empty path is returned. Standalone apks are
+// selected by the ABIs.
+func extractBundle(bundlePath string, apkInfos []ApkInfo, extractDir string, abis []string) (string, error) {
+	apkBytes, entryName, err := readBundleApk(bundlePath, abis)
+	if err != nil {
+		return "", err
+	}
+
+	// Skip bundles that have already been extracted, the apk may have been renamed since
+	hash := fmt.Sprintf("%x", sha256.Sum256(apkBytes))
+	for _, apkInfo := range apkInfos {
+		if apkInfo.Hash == hash {
+			fmt.Println("Bundle", bundlePath, "already extracted to", apkInfo.ApkPath)
+			return "", nil
+		}
+	}
+
+	apkPath := strings.TrimSuffix(bundlePath, filepath.Ext(bundlePath)) + ".apk"
+	if _, err := os.Stat(apkPath); err == nil {
+		return "", fmt.Errorf("cannot extract %s from bundle, %s already exists", entryName, apkPath)
+	}
+
+	err = os.MkdirAll(filepath.Join(extractDir, filepath.Dir(apkPath)), 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(extractDir, apkPath), apkBytes, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing file: %w", err)
+	}
+
+	fmt.Println("Extracted", entryName, "from bundle", bundlePath, "to", apkPath)
+
+	return apkPath, nil
+}
+
+// Extract the installable apk of the bundle to the directory, named after the bundle. Returns the path of the apk.
+func extractBundleApk(bundlePath, dir string, abis []string) (string, error) {
+	apkBytes, entryName, err := readBundleApk(bundlePath, abis)
+	if err != nil {
+		return "", err
+	}
+
+	apkPath := filepath.Join(dir, strings.TrimSuffix(filepath.Base(bundlePath), filepath.Ext(bundlePath))+".apk")
+	err = os.WriteFile(apkPath, apkBytes, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing file: %w", err)
+	}
+
+	fmt.Println("Extracted", entryName, "from bundle", bundlePath)
+	return apkPath, nil
+}
+
+// Find the bundle the apk path was extracted from, e.g. plugin.apks for plugin.apk. Returns empty path if there is none.
+func findBundleOfApk(apkPath string) string {
+	dir := filepath.Dir(apkPath)
+	stem := strings.TrimSuffix(filepath.Base(apkPath), filepath.Ext(apkPath))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if isBundleFile(name) && strings.TrimSuffix(name, filepath.Ext(name)) == stem {
+			return filepath.ToSlash(filepath.Join(dir, name))
+		}
+	}
+	return ""
+}
+
+// Create a temporary directory for apks extracted from bundles. Returns the directory and a function removing it.
+func createExtractDir() (string, func(), error) {
+	extractDir, err := os.MkdirTemp("", "taktool")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	return extractDir, func() { os.RemoveAll(extractDir) }, nil
+}
+
+// Apks extracted from bundles, which are written next to the bundles when packaging in place
+func extractedApkInfos(apkInfos []ApkInfo) []ApkInfo {
+	extracted := []ApkInfo{}
+	for _, apkInfo := range apkInfos {
+		if apkInfo.SourcePath != "" {
+			extracted = append(extracted, apkInfo)
+		}
+	}
+	return extracted
+}
+
+// Write the extracted apks next to their bundles in the transaction
+func writeExtractedApks(tx *transaction, extracted []ApkInfo) error {
+	for _, apkInfo := range extracted {
+		err := tx.writeFile(apkInfo.ApkPath, copyFile(apkInfo.SourcePath))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the apk that can be installed alone from the bundle.
+// Returns the apk content and the name of the bundle entry it was read from.
+func readBundleApk(bundlePath string, abis []string) ([]byte, string, error) {
+	zipReader, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening zip: %w", err)
+	}
+	defer zipReader.Close()
+
+	apkFiles := map[string]*zip.File{}
+	apkNames := []string{}
+	for _, zipFile := range zipReader.File {
+		// Expansion files are installed next to the apk, which the update server can not do
+		if strings.HasPrefix(zipFile.Name, "Android/obb/") || strings.HasSuffix(strings.ToLower(zipFile.Name), ".obb") {
+			return nil, "", errors.New("bundle contains OBB expansion files, which cannot be installed via the update server")
+		}
+		if isApkFile(zipFile.Name) {
+			apkFiles[zipFile.Name] = zipFile
+			apkNames = append(apkNames, zipFile.Name)
+		}
+	}
+	slices.Sort(apkNames)
+
+	if len(apkNames) == 0 {
+		return nil, "", errors.New("no apk files in bundle")
+	}
+
+	// Universal apk built by bundletool contains everything
+	if zipFile, ok := apkFiles["universal.apk"]; ok {
+		apkBytes, err := readZipFile(zipFile)
+		return apkBytes, zipFile.Name, err
+	}
+
+	// Standalone apks are complete apks for devices without split support
+	standaloneAbis := map[string][]string{}
+	for _, name := range apkNames {
+		if !strings.HasPrefix(name, "standalones/") {
+			continue
+		}
+		apkBytes, err := readZipFile(apkFiles[name])
+		if err != nil {
+			return nil, "", err
+		}
+		apkZipReader, err := zip.NewReader(bytes.NewReader(apkBytes), int64(len(apkBytes)))
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading %s: %w", name, err)
+		}
+		standaloneAbis[name], _ = readNativeLibraries(apkZipReader)
+	}
+	if len(standaloneAbis) > 0 {
+		name, err := selectStandaloneApk(standaloneAbis, abis)
+		if err != nil {
+			return nil, "", err
+		}
+		apkBytes, err := readZipFile(apkFiles[name])
+		return apkBytes, name, err
+	}
+
+	// Otherwise find the base apk among the splits
+	var baseBytes []byte
+	var baseName string
+	var baseManifest *manifest.Manifest
+	splits := 0
+	for _, name := range apkNames {
+		apkBytes, err := readZipFile(apkFiles[name])
+		if err != nil {
+			return nil, "", err
+		}
+		apkManifest, err := manifest.ParseApkReader(bytes.NewReader(apkBytes))
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading %s: %w", name, err)
+		}
+		if apkManifest.Split != "" {
+			splits++
+			continue
+		}
+		if baseManifest != nil {
+			return nil, "", fmt.Errorf("bundle contains several base apks: %s and %s", baseName, name)
+		}
+		baseBytes, baseName, baseManifest = apkBytes, name, apkManifest
+	}
+
+	if baseManifest == nil {
+		return nil, "", errors.New("no base apk in bundle")
+	}
+	if baseManifest.RequiresSplits() {
+		return nil, "", fmt.Errorf("base apk %s requires its %d split apks and cannot be installed via the update server, build a universal apk instead (bundletool build-apks --mode=universal)", baseName, splits)
+	}
+	// Splits may have the native libraries or resources the plugin needs
+	if splits > 0 {
+		return nil, "", fmt.Errorf("base apk %s cannot be installed without its %d split apks, build a universal apk instead (bundletool build-apks --mode=universal)", baseName, splits)
+	}
+
+	return baseBytes, baseName, nil
+}
+
+// Select the standalone apk for the first of the ABIs that has one, by the native libraries of the apks.
+// Standalone apks are built for each ABI and screen density, so the selected apk must be the only one
+// for the ABI. Without ABIs, the bundle must have only one standalone apk.
+func selectStandaloneApk(standaloneAbis map[string][]string, abis []string) (string, error) {
+	names := slices.Sorted(maps.Keys(standaloneAbis))
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	if len(abis) == 0 {
+		return "", fmt.Errorf("bundle contains %d standalone apks (%s), select one with -abi or build a universal apk (bundletool build-apks --mode=universal)", len(names), strings.Join(names, ", "))
+	}
+
+	for _, abi := range abis {
+		matching := []string{}
+		for _, name := range names {
+			if isAbiCompatible(ApkInfo{Abis: standaloneAbis[name]}, []string{abi}) {
+				matching = append(matching, name)
+			}
+		}
+		if len(matching) == 1 {
+			return matching[0], nil
+		}
+		if len(matching) > 1 {
+			return "", fmt.Errorf("bundle contains %d standalone apks for %s (%s), build a universal apk instead (bundletool build-apks --mode=universal)", len(matching), abi, strings.Join(matching, ", "))
+		}
+	}
+	return "", fmt.Errorf("bundle has no standalone apk for %s", strings.Join(abis, ", "))
+}
+
+// Read the whole content of a zip file entry
+func readZipFile(zipFile *zip.File) ([]byte, error) {
+	rc, err := zipFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", zipFile.Name, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", zipFile.Name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,74 @@
+package packager
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/internal/apktest"
+)
+
+func TestPackagePluginsExtractsBundleInTransaction(t *testing.T) {
+	t.Chdir(t.TempDir())
+	apk := apktest.Build(t, apktest.Manifest("com.example.plugin", 1, "Example"), nil)
+	bundle := apktest.Zip(t, map[string][]byte{"universal.apk": apk})
+	err := os.WriteFile("example.apks", bundle, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extracted apk is rolled back with the rest of the packaging
+	err = os.Mkdir(proructInfzFilename, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PackagePlugins(PluginsOptions{NoCache: true})
+	if err == nil {
+		t.Fatal("PackagePlugins() succeeded, want error writing product.infz")
+	}
+	if fileExists("example.apk") {
+		t.Error("example.apk was left behind by failed packaging")
+	}
+
+	err = os.Remove(proructInfzFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PackagePlugins(PluginsOptions{NoCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("example.apk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(apk) {
+		t.Error("example.apk is not the apk of the bundle")
+	}
+}
+
+func TestPackagePluginsFailsOnLooseSplits(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTestApk(t, ".", "base.apk", "com.example.plugin", 1, "Example")
+	split := apktest.Build(t, `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example.plugin"
+		split="config.arm64_v8a" android:versionCode="1"/>`, nil)
+	err := os.WriteFile("split_config.arm64_v8a.apk", split, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PackagePlugins(PluginsOptions{NoCache: true})
+	if err == nil || !strings.HasPrefix(err.Error(), "error reading base.apk: base.apk cannot be installed without its 1 split apks") {
+		t.Errorf("PackagePlugins() error = %v, want error about split apks of base.apk", err)
+	}
+
+	// Base apk is skipped with its splits if the packaging keeps going
+	err = PackagePlugins(PluginsOptions{NoCache: true, KeepGoing: true})
+	if !errors.Is(err, ErrPackagesSkipped) {
+		t.Fatalf("PackagePlugins() error = %v, want %v", err, ErrPackagesSkipped)
+	}
+	if strings.Contains(readTestProductInf(t, "."), "com.example.plugin") {
+		t.Error("product.inf lists the base apk of loose splits")
+	}
+}
@@ -0,0 +1,304 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+const metadataCacheFilename = "metadata-cache.json"
+
+// On-disk cache of package metadata in the user cache directory. Entries are found by the path,
+// size and modification time of the file, or by SHA-256 hash if the file has been renamed or copied.
+type metadataCache struct {
+	mu      sync.Mutex
+	path    string
+	version string
+	// Entries by hash
+	entries map[string]metadataCacheEntry
+	// Hashes by absolute path
+	hashes map[string]string
+	// Absolute paths of the files read in this run
+	used map[string]bool
+}
+
+// Package metadata of a file content. Copies of the file in several directories share the entry.
+type metadataCacheEntry struct {
+	Files []cachedFile `json:"files"`
+	Info  ApkInfo      `json:"info"`
+	Icon  []byte       `json:"icon,omitempty"`
+}
+
+// File the metadata was read from
+type cachedFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type metadataCacheFile struct {
+	Version string               `json:"version"`
+	Entries []metadataCacheEntry `json:"entries"`
+}
+
+// Version of the cache from the build of taktool, so that metadata cached by another build is read again.
+// Builds from a commit are told apart by the commit and the module versions, other builds by the executable.
+func metadataCacheVersion() string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, Version)
+
+	info, ok := debug.ReadBuildInfo()
+	modified := !ok
+	if ok {
+		fmt.Fprintln(hash, info.GoVersion, info.Main.Path, info.Main.Version, info.Main.Sum)
+		for _, dep := range info.Deps {
+			fmt.Fprintln(hash, dep.Path, dep.Version, dep.Sum)
+			if dep.Replace != nil {
+				fmt.Fprintln(hash, dep.Replace.Path, dep.Replace.Version, dep.Replace.Sum)
+			}
+		}
+		revision := ""
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = modified || setting.Value == "true"
+			}
+		}
+		fmt.Fprintln(hash, revision)
+		if revision == "" && (info.Main.Version == "" || info.Main.Version == "(devel)") {
+			modified = true
+		}
+	}
+
+	// Sources of the build are not known, the executable tells the builds apart
+	if modified {
+		executable, err := os.Executable()
+		if err == nil {
+			f, err := os.Open(executable)
+			if err == nil {
+				io.Copy(hash, f)
+				f.Close()
+			}
+		}
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Open metadata cache. Missing cache, cache of another version or an unreadable cache is started empty.
+func openMetadataCache() (*metadataCache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("error getting cache directory: %w", err)
+	}
+
+	cache := &metadataCache{
+		path:    filepath.Join(cacheDir, "taktool", metadataCacheFilename),
+		version: metadataCacheVersion(),
+		hashes:  map[string]string{},
+		used:    map[string]bool{},
+	}
+	cache.entries = readMetadataCacheFile(cache.path, cache.version)
+	for hash, entry := range cache.entries {
+		for _, file := range entry.Files {
+			cache.hashes[file.Path] = hash
+		}
+	}
+
+	return cache, nil
+}
+
+// Read cache entries by hash from the cache file. Missing or unreadable file or file of another version has no entries.
+func readMetadataCacheFile(cachePath, version string) map[string]metadataCacheEntry {
+	entries := map[string]metadataCacheEntry{}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return entries
+	}
+	cacheFile := metadataCacheFile{}
+	if json.Unmarshal(data, &cacheFile) != nil || cacheFile.Version != version {
+		return entries
+	}
+	for _, entry := range cacheFile.Entries {
+		entries[entry.Info.Hash] = entry
+	}
+	return entries
+}
+
+// Read package information from the cache, or with the reader if the file is not in the cache
+func (c *metadataCache) readArtifact(reader artifact.Reader, filePath string) (ApkInfo, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("error getting file info: %w", err)
+	}
+	file := cachedFile{Path: absPath, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}
+
+	// Unchanged file
+	c.mu.Lock()
+	entry, ok := c.entries[c.hashes[absPath]]
+	unchanged := ok && entry.Info.Format == reader.Name() && slices.ContainsFunc(entry.Files, func(cached cachedFile) bool {
+		return cached.Path == file.Path && cached.Size == file.Size && cached.ModTime.Equal(file.ModTime)
+	})
+	c.mu.Unlock()
+	if unchanged {
+		return c.use(entry, file, filePath), nil
+	}
+
+	// Renamed or copied file has the same hash. The file is hashed before reading it only if a cached file
+	// has the same size, otherwise the hash is calculated when the file is read.
+	if c.hasEntryOfSize(reader.Name(), file.Size) {
+		hash, err := reader.Hash(filePath)
+		if err != nil {
+			return ApkInfo{}, fmt.Errorf("error calculating hash: %w", err)
+		}
+		c.mu.Lock()
+		entry, ok = c.entries[hash]
+		c.mu.Unlock()
+		if ok && entry.Info.Format == reader.Name() {
+			return c.use(entry, file, filePath), nil
+		}
+	}
+
+	info, err := readArtifact(reader, filePath)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+
+	// Cache the icon too, so that the file is not read again when packaging
+	if info.IconData == nil {
+		info.IconData, err = reader.Icon(filePath, info)
+		if err != nil {
+			return ApkInfo{}, fmt.Errorf("error reading icon: %w", err)
+		}
+	}
+
+	c.use(metadataCacheEntry{Info: info, Icon: info.IconData}, file, filePath)
+	return info, nil
+}
+
+// Check if the cache has an entry of the package type and file size
+func (c *metadataCache) hasEntryOfSize(format string, size int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.Info.Format == format && int64(entry.Info.Size) == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Store the file in the entry and get the package information from it
+func (c *metadataCache) use(entry metadataCacheEntry, file cachedFile, filePath string) ApkInfo {
+	entry.Info.IconData = nil
+
+	c.mu.Lock()
+	// File had another content before
+	if oldHash, ok := c.hashes[file.Path]; ok && oldHash != entry.Info.Hash {
+		if oldEntry, ok := c.entries[oldHash]; ok {
+			oldEntry.Files = withoutCachedFile(oldEntry.Files, file.Path)
+			c.entries[oldHash] = oldEntry
+		}
+	}
+	if existing, ok := c.entries[entry.Info.Hash]; ok && existing.Info.Format == entry.Info.Format {
+		entry.Files = existing.Files
+	}
+	entry.Files = append(withoutCachedFile(entry.Files, file.Path), file)
+	c.entries[entry.Info.Hash] = entry
+	c.hashes[file.Path] = entry.Info.Hash
+	c.used[file.Path] = true
+	c.mu.Unlock()
+
+	info := entry.Info
+	info.ApkPath = cleanupValue(filePath)
+	info.SourcePath = ""
+	info.IconData = entry.Icon
+	return info
+}
+
+// Copy of the files without the file of the path
+func withoutCachedFile(files []cachedFile, path string) []cachedFile {
+	return slices.DeleteFunc(slices.Clone(files), func(file cachedFile) bool {
+		return file.Path == path
+	})
+}
+
+// Write the cache to disk. Entries saved by other taktool runs since the cache was opened are kept.
+// Files that no longer exist or have changed are left out.
+func (c *metadataCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cacheDir := filepath.Dir(c.path)
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	unlock, err := lockDirectory(cacheDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries := readMetadataCacheFile(c.path, c.version)
+	for hash, entry := range entries {
+		// Files read in this run are stored with their current content
+		entry.Files = slices.DeleteFunc(entry.Files, func(file cachedFile) bool {
+			_, ok := c.hashes[file.Path]
+			return ok
+		})
+		entries[hash] = entry
+	}
+	for hash, entry := range c.entries {
+		if saved, ok := entries[hash]; ok && saved.Info.Format == entry.Info.Format {
+			entry.Files = append(slices.Clone(entry.Files), saved.Files...)
+		}
+		entries[hash] = entry
+	}
+
+	cacheFile := metadataCacheFile{Version: c.version, Entries: []metadataCacheEntry{}}
+	for _, entry := range entries {
+		entry.Files = slices.DeleteFunc(slices.Clone(entry.Files), func(file cachedFile) bool {
+			if c.used[file.Path] {
+				return false
+			}
+			fileInfo, err := os.Stat(file.Path)
+			return err != nil || fileInfo.Size() != file.Size || !fileInfo.ModTime().Equal(file.ModTime)
+		})
+		if len(entry.Files) > 0 {
+			cacheFile.Entries = append(cacheFile.Entries, entry)
+		}
+	}
+
+	data, err := json.Marshal(cacheFile)
+	if err != nil {
+		return fmt.Errorf("error encoding cache: %w", err)
+	}
+
+	tempPath := c.path + fmt.Sprintf(".%d.tmp", os.Getpid())
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	err = os.Rename(tempPath, c.path)
+	if err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+
+	return nil
+}
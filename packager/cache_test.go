@@ -0,0 +1,99 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// Reader counting how many times files are read and hashed
+type countingReader struct {
+	reads  int
+	hashes int
+}
+
+func (r *countingReader) Name() string            { return "counting" }
+func (r *countingReader) Detect(path string) bool { return strings.HasSuffix(path, ".counting") }
+func (r *countingReader) Read(path string) (ApkInfo, error) {
+	r.reads++
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+	return ApkInfo{Platform: "Android", Type: "plugin", Package: string(data), Revision: "1"}, nil
+}
+func (r *countingReader) Icon(path string, info ApkInfo) ([]byte, error) { return []byte("icon"), nil }
+func (r *countingReader) Hash(path string) (string, error) {
+	r.hashes++
+	return artifact.HashFile(path)
+}
+
+func TestMetadataCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	reader := &countingReader{}
+	filePath := filepath.Join(dir, "a.counting")
+	writeTestFiles(t, dir, map[string]string{"a.counting": "com.a"})
+
+	cache, err := openMetadataCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(filePath string, wantReads, wantHashes int, wantPackage string) {
+		t.Helper()
+		reader.reads, reader.hashes = 0, 0
+		info, err := cache.readArtifact(reader, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reader.reads != wantReads || reader.hashes != wantHashes {
+			t.Errorf("read %d and hashed %d times, want %d and %d", reader.reads, reader.hashes, wantReads, wantHashes)
+		}
+		if info.Package != wantPackage || info.ApkPath != filepath.ToSlash(filePath) || string(info.IconData) != "icon" {
+			t.Errorf("info = %+v, want package %s from %s", info, wantPackage, filePath)
+		}
+	}
+
+	// New file is hashed once
+	readFile(filePath, 1, 1, "com.a")
+
+	// Unchanged file is not read or hashed
+	readFile(filePath, 0, 0, "com.a")
+
+	// Renamed file is found by hash
+	renamedPath := filepath.Join(dir, "renamed.counting")
+	err = os.Rename(filePath, renamedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile(renamedPath, 0, 1, "com.a")
+
+	// Changed file of another size is read and hashed once
+	writeTestFiles(t, dir, map[string]string{"renamed.counting": "com.changed"})
+	readFile(renamedPath, 1, 1, "com.changed")
+
+	// Changed file of the same size is hashed before reading, as it could be a renamed file
+	writeTestFiles(t, dir, map[string]string{"renamed.counting": "com.another"})
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(renamedPath, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile(renamedPath, 1, 2, "com.another")
+
+	// Saved cache is used by the next run
+	err = cache.save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err = openMetadataCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile(renamedPath, 0, 0, "com.another")
+}
@@ -0,0 +1,221 @@
+package packager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf16"
+)
+
+// Compound File Binary format used by MSI files, see [MS-CFB]
+const (
+	cfbSignature     = 0xE11AB1A1E011CFD0
+	cfbEndOfChain    = 0xFFFFFFFE
+	cfbFreeSector    = 0xFFFFFFFF
+	cfbHeaderSize    = 512
+	cfbDirEntrySize  = 128
+	cfbEntryStream   = 2
+	cfbEntryRoot     = 5
+	cfbMaxChainCount = 1 << 24
+)
+
+// Read only compound file with all streams of the file by their raw names
+type compoundFile struct {
+	data            []byte
+	sectorSize      int
+	miniSectorSize  int
+	miniStreamLimit uint64
+	fat             []uint32
+	miniFat         []uint32
+	miniStream      []byte
+	// Streams by name, storages are flattened
+	streams map[string]cfbEntry
+}
+
+type cfbEntry struct {
+	startSector uint32
+	size        uint64
+}
+
+// Open compound file and read its directory
+func openCompoundFile(filePath string) (*compoundFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	return parseCompoundFile(data)
+}
+
+// Parse compound file from data
+func parseCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < cfbHeaderSize || binary.LittleEndian.Uint64(data[0:8]) != cfbSignature {
+		return nil, errors.New("not a compound file")
+	}
+
+	sectorShift := binary.LittleEndian.Uint16(data[0x1E:])
+	miniSectorShift := binary.LittleEndian.Uint16(data[0x20:])
+	if sectorShift != 9 && sectorShift != 12 || miniSectorShift != 6 {
+		return nil, errors.New("unsupported compound file sector size")
+	}
+
+	cf := &compoundFile{
+		data:            data,
+		sectorSize:      1 << sectorShift,
+		miniSectorSize:  1 << miniSectorShift,
+		miniStreamLimit: uint64(binary.LittleEndian.Uint32(data[0x38:])),
+		streams:         map[string]cfbEntry{},
+	}
+
+	// Collect FAT sectors from the header and DIFAT chain
+	numFatSectors := int(binary.LittleEndian.Uint32(data[0x2C:]))
+	fatSectors := []uint32{}
+	for i := 0; i < 109 && len(fatSectors) < numFatSectors; i++ {
+		fatSectors = append(fatSectors, binary.LittleEndian.Uint32(data[0x4C+i*4:]))
+	}
+	difatSector := binary.LittleEndian.Uint32(data[0x44:])
+	for difatSector != cfbEndOfChain && difatSector != cfbFreeSector && len(fatSectors) < numFatSectors {
+		sector, err := cf.sector(difatSector)
+		if err != nil {
+			return nil, fmt.Errorf("error reading DIFAT: %w", err)
+		}
+		entries := cf.sectorSize/4 - 1
+		for i := 0; i < entries && len(fatSectors) < numFatSectors; i++ {
+			fatSectors = append(fatSectors, binary.LittleEndian.Uint32(sector[i*4:]))
+		}
+		difatSector = binary.LittleEndian.Uint32(sector[entries*4:])
+	}
+
+	for _, fatSector := range fatSectors {
+		sector, err := cf.sector(fatSector)
+		if err != nil {
+			return nil, fmt.Errorf("error reading FAT: %w", err)
+		}
+		for i := 0; i < cf.sectorSize; i += 4 {
+			cf.fat = append(cf.fat, binary.LittleEndian.Uint32(sector[i:]))
+		}
+	}
+
+	// Read directory entries
+	directory, err := cf.readChain(binary.LittleEndian.Uint32(data[0x30:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	var root *cfbEntry
+	for pos := 0; pos+cfbDirEntrySize <= len(directory); pos += cfbDirEntrySize {
+		entry := directory[pos : pos+cfbDirEntrySize]
+		entryType := entry[66]
+		nameLen := int(binary.LittleEndian.Uint16(entry[64:]))
+		if nameLen < 2 || nameLen > 64 {
+			continue
+		}
+
+		chars := make([]uint16, nameLen/2-1)
+		for i := range chars {
+			chars[i] = binary.LittleEndian.Uint16(entry[i*2:])
+		}
+		dirEntry := cfbEntry{
+			startSector: binary.LittleEndian.Uint32(entry[116:]),
+			size:        binary.LittleEndian.Uint64(entry[120:]),
+		}
+		// Version 3 files may have garbage in the high part of the size
+		if cf.sectorSize == 512 {
+			dirEntry.size &= 0xFFFFFFFF
+		}
+
+		switch entryType {
+		case cfbEntryRoot:
+			root = &dirEntry
+		case cfbEntryStream:
+			cf.streams[string(utf16.Decode(chars))] = dirEntry
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("compound file has no root entry")
+	}
+
+	// Small streams are stored in the mini stream of the root entry
+	cf.miniStream, err = cf.readChain(root.startSector, root.size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mini stream: %w", err)
+	}
+	miniFatData, err := cf.readChain(binary.LittleEndian.Uint32(data[0x3C:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mini FAT: %w", err)
+	}
+	for i := 0; i+4 <= len(miniFatData); i += 4 {
+		cf.miniFat = append(cf.miniFat, binary.LittleEndian.Uint32(miniFatData[i:]))
+	}
+
+	return cf, nil
+}
+
+// Get sector data by sector number
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	offset := (uint64(n) + 1) * uint64(cf.sectorSize)
+	if offset+uint64(cf.sectorSize) > uint64(len(cf.data)) {
+		return nil, fmt.Errorf("sector %d out of bounds", n)
+	}
+	return cf.data[offset : offset+uint64(cf.sectorSize)], nil
+}
+
+// Read sector chain starting from sector. If size is 0, the whole chain is read.
+func (cf *compoundFile) readChain(start uint32, size uint64) ([]byte, error) {
+	result := []byte{}
+	for n, count := start, 0; n != cfbEndOfChain && n != cfbFreeSector; count++ {
+		if count > cfbMaxChainCount || int(n) >= len(cf.fat) {
+			return nil, errors.New("invalid sector chain")
+		}
+		sector, err := cf.sector(n)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sector...)
+		if size > 0 && uint64(len(result)) >= size {
+			break
+		}
+		n = cf.fat[n]
+	}
+	if size > uint64(len(result)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if size > 0 {
+		result = result[:size]
+	}
+	return result, nil
+}
+
+// Read mini sector chain from the mini stream
+func (cf *compoundFile) readMiniChain(start uint32, size uint64) ([]byte, error) {
+	result := []byte{}
+	for n, count := start, 0; uint64(len(result)) < size; count++ {
+		if count > cfbMaxChainCount || int(n) >= len(cf.miniFat) {
+			return nil, errors.New("invalid mini sector chain")
+		}
+		offset := int(n) * cf.miniSectorSize
+		if offset+cf.miniSectorSize > len(cf.miniStream) {
+			return nil, fmt.Errorf("mini sector %d out of bounds", n)
+		}
+		result = append(result, cf.miniStream[offset:offset+cf.miniSectorSize]...)
+		n = cf.miniFat[n]
+	}
+	return result[:size], nil
+}
+
+// Read stream by its raw name. Returns nil if the stream does not exist.
+func (cf *compoundFile) readStream(name string) ([]byte, error) {
+	entry, ok := cf.streams[name]
+	if !ok {
+		return nil, nil
+	}
+	if entry.size == 0 {
+		return []byte{}, nil
+	}
+	if entry.size < cf.miniStreamLimit {
+		return cf.readMiniChain(entry.startSector, entry.size)
+	}
+	return cf.readChain(entry.startSector, entry.size)
+}
@@ -0,0 +1,238 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+// Stream of a compound file fixture
+type testStream struct {
+	name string
+	data []byte
+}
+
+// Build a version 3 compound file with 512 byte sectors. Streams smaller than 4096 bytes are stored in the mini stream.
+// Sectors are FAT sectors, directory, mini FAT, mini stream and then the other streams.
+func buildCompoundFile(streams []testStream) []byte {
+	const sectorSize = 512
+	const miniSectorSize = 64
+	const miniStreamLimit = 4096
+	sectors := func(size, sectorSize int) int {
+		return (size + sectorSize - 1) / sectorSize
+	}
+
+	// Lay out the mini stream and the mini FAT
+	miniStream := []byte{}
+	miniFat := []uint32{}
+	starts := make([]uint32, len(streams))
+	for i, stream := range streams {
+		starts[i] = cfbEndOfChain
+		if len(stream.data) == 0 || len(stream.data) >= miniStreamLimit {
+			continue
+		}
+		starts[i] = uint32(len(miniFat))
+		n := sectors(len(stream.data), miniSectorSize)
+		for j := range n {
+			next := uint32(len(miniFat) + 1)
+			if j == n-1 {
+				next = cfbEndOfChain
+			}
+			miniFat = append(miniFat, next)
+		}
+		miniStream = append(miniStream, stream.data...)
+		miniStream = append(miniStream, make([]byte, n*miniSectorSize-len(stream.data))...)
+	}
+
+	numDirSectors := sectors((len(streams)+1)*cfbDirEntrySize, sectorSize)
+	numMiniStreamSectors := sectors(len(miniStream), sectorSize)
+	numOtherSectors := 1 + numDirSectors + numMiniStreamSectors
+	for _, stream := range streams {
+		if len(stream.data) >= miniStreamLimit {
+			numOtherSectors += sectors(len(stream.data), sectorSize)
+		}
+	}
+	numFatSectors := 1
+	for numFatSectors*sectorSize/4 < numFatSectors+numOtherSectors {
+		numFatSectors++
+	}
+
+	fat := make([]uint32, numFatSectors*sectorSize/4)
+	for i := range fat {
+		fat[i] = cfbFreeSector
+	}
+	body := []byte{}
+	nextSector := uint32(numFatSectors)
+	// Add sectors of the data as a chain and return the first sector
+	addChain := func(data []byte) uint32 {
+		start := nextSector
+		n := sectors(len(data), sectorSize)
+		for j := range n {
+			fat[nextSector] = nextSector + 1
+			if j == n-1 {
+				fat[nextSector] = cfbEndOfChain
+			}
+			nextSector++
+		}
+		body = append(body, data...)
+		body = append(body, make([]byte, n*sectorSize-len(data))...)
+		return start
+	}
+	for i := range numFatSectors {
+		fat[i] = 0xFFFFFFFD
+	}
+
+	directory := make([]byte, numDirSectors*sectorSize)
+	dirStart := addChain(directory)
+	miniFatData := make([]byte, 0, len(miniFat)*4)
+	for _, next := range miniFat {
+		miniFatData = binary.LittleEndian.AppendUint32(miniFatData, next)
+	}
+	miniFatStart := addChain(miniFatData)
+	miniStreamStart := uint32(cfbEndOfChain)
+	if len(miniStream) > 0 {
+		miniStreamStart = addChain(miniStream)
+	}
+	for i, stream := range streams {
+		if len(stream.data) >= miniStreamLimit {
+			starts[i] = addChain(stream.data)
+		}
+	}
+
+	// Directory is written in place, the body has the sectors after the FAT
+	writeEntry := func(index int, name string, entryType byte, start uint32, size int) {
+		entry := body[index*cfbDirEntrySize:]
+		chars := utf16.Encode([]rune(name))
+		for i, c := range chars {
+			binary.LittleEndian.PutUint16(entry[i*2:], c)
+		}
+		binary.LittleEndian.PutUint16(entry[64:], uint16(len(chars)*2+2))
+		entry[66] = entryType
+		binary.LittleEndian.PutUint32(entry[68:], cfbFreeSector)
+		binary.LittleEndian.PutUint32(entry[72:], cfbFreeSector)
+		binary.LittleEndian.PutUint32(entry[76:], cfbFreeSector)
+		binary.LittleEndian.PutUint32(entry[116:], start)
+		binary.LittleEndian.PutUint64(entry[120:], uint64(size))
+	}
+	writeEntry(0, "Root Entry", cfbEntryRoot, miniStreamStart, len(miniStream))
+	for i, stream := range streams {
+		writeEntry(i+1, stream.name, cfbEntryStream, starts[i], len(stream.data))
+	}
+
+	header := make([]byte, cfbHeaderSize)
+	binary.LittleEndian.PutUint64(header[0:], cfbSignature)
+	binary.LittleEndian.PutUint16(header[0x18:], 0x3E)
+	binary.LittleEndian.PutUint16(header[0x1A:], 3)
+	binary.LittleEndian.PutUint16(header[0x1C:], 0xFFFE)
+	binary.LittleEndian.PutUint16(header[0x1E:], 9)
+	binary.LittleEndian.PutUint16(header[0x20:], 6)
+	binary.LittleEndian.PutUint32(header[0x2C:], uint32(numFatSectors))
+	binary.LittleEndian.PutUint32(header[0x30:], dirStart)
+	binary.LittleEndian.PutUint32(header[0x38:], miniStreamLimit)
+	binary.LittleEndian.PutUint32(header[0x3C:], miniFatStart)
+	binary.LittleEndian.PutUint32(header[0x40:], uint32(sectors(len(miniFatData), sectorSize)))
+	binary.LittleEndian.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := range 109 {
+		sector := uint32(cfbFreeSector)
+		if i < numFatSectors {
+			sector = uint32(i)
+		}
+		binary.LittleEndian.PutUint32(header[0x4C+i*4:], sector)
+	}
+
+	fatData := make([]byte, 0, len(fat)*4)
+	for _, next := range fat {
+		fatData = binary.LittleEndian.AppendUint32(fatData, next)
+	}
+	return slices.Concat(header, fatData, body)
+}
+
+func TestCompoundFileStreams(t *testing.T) {
+	small := bytes.Repeat([]byte("small"), 20)
+	mini := bytes.Repeat([]byte{1}, 64)
+	big := bytes.Repeat([]byte("0123456789"), 500)
+	data := buildCompoundFile([]testStream{
+		{name: "small", data: small},
+		{name: "mini", data: mini},
+		{name: "big", data: big},
+		{name: "empty", data: []byte{}},
+	})
+
+	cf, err := parseCompoundFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{name: "small", want: small},
+		{name: "mini", want: mini},
+		{name: "big", want: big},
+		{name: "empty", want: []byte{}},
+		{name: "missing", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cf.readStream(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("readStream(%q) = %d bytes, want %d bytes", tt.name, len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCompoundFileErrors(t *testing.T) {
+	valid := buildCompoundFile([]testStream{{name: "small", data: []byte("data")}})
+	modified := func(modify func(data []byte)) []byte {
+		data := bytes.Clone(valid)
+		modify(data)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "short",
+			data: valid[:100],
+			want: "not a compound file",
+		},
+		{
+			name: "signature",
+			data: modified(func(data []byte) { data[0] = 0 }),
+			want: "not a compound file",
+		},
+		{
+			name: "sector size",
+			data: modified(func(data []byte) { binary.LittleEndian.PutUint16(data[0x1E:], 10) }),
+			want: "unsupported compound file sector size",
+		},
+		{
+			name: "directory out of bounds",
+			data: modified(func(data []byte) { binary.LittleEndian.PutUint32(data[0x30:], 1000) }),
+			want: "error reading directory: invalid sector chain",
+		},
+		{
+			name: "no root",
+			data: modified(func(data []byte) { data[2*cfbHeaderSize+66] = cfbEntryStream }),
+			want: "compound file has no root entry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCompoundFile(tt.data)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("parseCompoundFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
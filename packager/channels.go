@@ -0,0 +1,169 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// Directory of release channels. Every channel, e.g. channels/beta, is a plugins repository with its own product.infz.
+const channelsDirname = "channels"
+
+// Directory of the channel
+func ChannelDir(name string) string {
+	return filepath.Join(channelsDirname, name)
+}
+
+// Copy the published files of the package from one channel to another by hash, and package the target channel again
+func PromotePlugin(packageName, from, to string, opts PluginsOptions) error {
+	if from == "" || to == "" {
+		return fmt.Errorf("source and target channels must be set with -from and -to")
+	}
+	if from == to {
+		return fmt.Errorf("source and target channels are the same")
+	}
+
+	fromDir := ChannelDir(from)
+	toDir := ChannelDir(to)
+
+	apkInfos, err := readProductInfz(filepath.Join(fromDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+	targetInfos, err := readProductInfz(filepath.Join(toDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+
+	// Files are copied only if they are exactly the ones published in the source channel
+	promoted := []ApkInfo{}
+	for _, apkInfo := range apkInfos {
+		if apkInfo.Package != packageName {
+			continue
+		}
+
+		hash, err := calculateHash(filepath.Join(fromDir, apkInfo.ApkPath))
+		if err != nil {
+			return fmt.Errorf("error reading %s of channel %s: %w", apkInfo.ApkPath, from, err)
+		}
+		if hash != apkInfo.Hash {
+			return fmt.Errorf("%s of channel %s does not match its %s, package the channel first", apkInfo.ApkPath, from, proructInfzFilename)
+		}
+
+		if containsHash(targetInfos, apkInfo.Hash) {
+			fmt.Println(apkInfo.DisplayName, "revision", apkInfo.Revision, "is already in channel", to)
+			continue
+		}
+		promoted = append(promoted, apkInfo)
+	}
+	if len(promoted) == 0 {
+		if containsPackageName(apkInfos, packageName) {
+			return nil
+		}
+		return fmt.Errorf("package %s is not in channel %s", packageName, from)
+	}
+
+	err = os.MkdirAll(toDir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating channel directory: %w", err)
+	}
+	fromDir, err = filepath.Abs(fromDir)
+	if err != nil {
+		return fmt.Errorf("error getting channel directory: %w", err)
+	}
+
+	// Package the target channel in its directory, with its own policy, pins and descriptions
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting working directory: %w", err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(toDir)
+	if err != nil {
+		return fmt.Errorf("error changing directory: %w", err)
+	}
+	opts.OutDir = ""
+
+	// Promoting would race with another taktool changing the target channel
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(".")
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	// Promoted files are copied in the transaction of packaging the target channel, and rolled back if it fails
+	copied, err := readPromotedFiles(promoted, fromDir)
+	if err != nil {
+		return err
+	}
+	var failures []readFailure
+	err = inTransaction(".", func(tx *transaction) error {
+		failures, err = packageDirectory(tx, ".", false, copied, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, apkInfo := range promoted {
+		fmt.Println("Promoted", apkInfo.DisplayName, "revision", apkInfo.Revision, "from", from, "to", to)
+	}
+	return printPackaged(".", failures)
+}
+
+// Read the package files of the source channel to be copied to the same paths in the target channel in the working
+// directory, or next to them if the paths are taken
+func readPromotedFiles(apkInfos []ApkInfo, fromDir string) ([]ApkInfo, error) {
+	copied := []ApkInfo{}
+	for _, apkInfo := range apkInfos {
+		sourcePath := filepath.Join(fromDir, apkInfo.ApkPath)
+		reader := artifact.ForFile(sourcePath)
+		if reader == nil {
+			return nil, fmt.Errorf("%s is not a package file", apkInfo.ApkPath)
+		}
+		copiedInfo, err := readArtifact(reader, sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", sourcePath, err)
+		}
+		copiedInfo.ApkPath, err = freePluginFilePath(apkInfo.ApkPath, nil, nil, ".")
+		if err != nil {
+			return nil, err
+		}
+		copiedInfo.SourcePath = sourcePath
+		copied = append(copied, copiedInfo)
+	}
+
+	// Descriptions of the target channel are used, like for the other files of the channel
+	descriptions, err := loadDescriptions(descriptionsFilename)
+	if err != nil {
+		return nil, err
+	}
+	applyDescriptions(copied, descriptions)
+	return copied, nil
+}
+
+// Check if a package with the hash is in the list
+func containsHash(apkInfos []ApkInfo, hash string) bool {
+	for _, apkInfo := range apkInfos {
+		if apkInfo.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the package is in the list
+func containsPackageName(apkInfos []ApkInfo, packageName string) bool {
+	for _, apkInfo := range apkInfos {
+		if apkInfo.Package == packageName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPromotePlugin(t *testing.T) {
+	t.Chdir(t.TempDir())
+	opts := PluginsOptions{PolicyFile: DefaultPolicyFilename, PinsFile: DefaultPinsFilename, NoCache: true}
+	for _, channel := range []string{"beta", "stable"} {
+		err := os.MkdirAll(ChannelDir(channel), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestApk(t, ChannelDir("beta"), "example.apk", "com.example.plugin", 1, "Example")
+	inTestDir(t, ChannelDir("beta"), func() error { return PackagePlugins(opts) })
+	inTestDir(t, ChannelDir("stable"), func() error { return PackagePlugins(opts) })
+
+	// Copied file is rolled back if the policy of the target channel denies it
+	writeTestFiles(t, ChannelDir("stable"), map[string]string{DefaultPolicyFilename: `{"minTargetSdk": 34, "buildIssues": "fail"}`})
+	err := PromotePlugin("com.example.plugin", "beta", "stable", opts)
+	if err == nil {
+		t.Fatal("PromotePlugin() succeeded, want policy error of the target channel")
+	}
+	if fileExists(filepath.Join(ChannelDir("stable"), "example.apk")) {
+		t.Error("example.apk was left in the target channel by failed promote")
+	}
+	if strings.Contains(readTestProductInf(t, ChannelDir("stable")), "com.example.plugin") {
+		t.Error("product.inf of the target channel lists the plugin after failed promote")
+	}
+
+	err = os.Remove(filepath.Join(ChannelDir("stable"), DefaultPolicyFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PromotePlugin("com.example.plugin", "beta", "stable", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(ChannelDir("stable"), "example.apk")) {
+		t.Error("example.apk was not copied to the target channel")
+	}
+	if !strings.Contains(readTestProductInf(t, ChannelDir("stable")), "com.example.plugin") {
+		t.Error("product.inf of the target channel does not list the promoted plugin")
+	}
+}
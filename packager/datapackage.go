@@ -1,4 +1,4 @@
-package main
+package packager
 
 import (
 	"archive/zip"
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 
@@ -20,7 +21,7 @@ type Manifest struct {
 	OnReceiveImport bool
 }
 
-func PackageDataPackage(uid, name, fileExtension string, onReceiveDelete, onReceiveImport bool) error {
+func PackageDataPackage(uid, name, fileExtension string, onReceiveDelete, onReceiveImport bool, outDir string) error {
 
 	var err error
 	var UID uuid.UUID
@@ -82,12 +83,21 @@ func PackageDataPackage(uid, name, fileExtension string, onReceiveDelete, onRece
 		}
 	}
 
-	err = makeDataPackage(manifest, dataPackageName)
+	// Write data package to the output directory, default is the current directory
+	dataPackagePath := filepath.Join(outDir, dataPackageName)
+
+	// Earlier data packages are not packed, e.g. when the output directory is inside the input directory
+	manifest.FileContents, err = excludeOutputs(manifest.FileContents, outDir, dataPackagePath)
+	if err != nil {
+		return fmt.Errorf("error checking output directory: %w", err)
+	}
+
+	err = makeDataPackage(manifest, dataPackagePath)
 	if err != nil {
 		return fmt.Errorf("error making data package: %w", err)
 	}
 
-	fmt.Println("Data package created:", dataPackageName)
+	fmt.Println("Data package created:", dataPackagePath)
 
 	return nil
 }
@@ -99,7 +109,7 @@ func makeDataPackage(manifest Manifest, name string) error {
 		return fmt.Errorf("error building manifest: %w", err)
 	}
 
-	// Create zip file to the working directory
+	// Create zip file
 	file, err := os.Create(name)
 	if err != nil {
 		return fmt.Errorf("error creating file: %w", err)
@@ -142,6 +152,38 @@ func makeDataPackage(manifest Manifest, name string) error {
 	return nil
 }
 
+// Leave out the data package and the files in the output directory if it is another directory
+func excludeOutputs(files []string, outDir, dataPackagePath string) ([]string, error) {
+	dataPackagePath, err := filepath.Abs(dataPackagePath)
+	if err != nil {
+		return nil, err
+	}
+	otherOutDir := false
+	if outDir != "" {
+		otherOutDir, err = isOtherDirectory(outDir)
+		if err != nil {
+			return nil, err
+		}
+		outDir, err = filepath.Abs(outDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	included := []string{}
+	for _, file := range files {
+		filePath, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		if filePath == dataPackagePath || (otherOutDir && strings.HasPrefix(filePath, outDir+string(filepath.Separator))) {
+			continue
+		}
+		included = append(included, file)
+	}
+	return included, nil
+}
+
 func readDirFiles(path string) ([]string, error) {
 	files, err := os.ReadDir(path)
 	if err != nil {
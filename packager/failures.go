@@ -0,0 +1,47 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pvarki/golang-tak-taktool/manifest"
+)
+
+// Report of files that could not be read in keep going mode, written next to product.infz
+const failureReportFilename = "product.failures.txt"
+
+// ErrPackagesSkipped is returned when packaging succeeded but some files could not be read
+var ErrPackagesSkipped = errors.New("some files could not be read and were skipped")
+
+// File that could not be read
+type readFailure struct {
+	Path string
+	// Stage of reading that failed: zip, resources, manifest or read
+	Stage string
+	Err   error
+}
+
+func newReadFailure(filePath string, err error) readFailure {
+	failure := readFailure{Path: filePath, Stage: "read", Err: err}
+
+	var parseErr *manifest.ParseError
+	if errors.As(err, &parseErr) {
+		failure.Stage = parseErr.Stage
+	}
+
+	return failure
+}
+
+func (f readFailure) String() string {
+	return fmt.Sprintf("%s (%s): %v", f.Path, f.Stage, f.Err)
+}
+
+// Create failure report text, one file per line
+func createFailureReport(failures []readFailure) string {
+	var report strings.Builder
+	for _, failure := range failures {
+		report.WriteString(failure.String() + "\n")
+	}
+	return report.String()
+}
@@ -0,0 +1,340 @@
+package packager
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// Directories in the apk directory for new builds waiting for approval and for rejected builds
+const (
+	incomingDirname = "incoming"
+	rejectedDirname = "rejected"
+)
+
+// Suffix of the file next to a rejected build with the reason
+const rejectionReasonSuffix = ".reason.txt"
+
+// Build in the incoming directory with the results of its checks
+type pendingPlugin struct {
+	ApkInfo
+	// Problems reject the build, warnings are only shown
+	Problems []string
+	Warnings []string
+}
+
+// Incoming directory of the apk directory
+func incomingDir(apkDir string) string {
+	return path.Join(filepath.ToSlash(cmp.Or(apkDir, ".")), incomingDirname)
+}
+
+// Rejected directory of the apk directory
+func rejectedDir(apkDir string) string {
+	return path.Join(filepath.ToSlash(cmp.Or(apkDir, ".")), rejectedDirname)
+}
+
+// Read and check builds in the incoming directory. Files that can not be read are returned as failures.
+func readPendingPlugins(opts PluginsOptions) ([]pendingPlugin, []readFailure, error) {
+	dir := incomingDir(opts.ApkDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	policy, err := loadPolicy(opts.PolicyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading policy: %w", err)
+	}
+
+	outDir := cmp.Or(opts.OutDir, ".")
+	published, err := readProductInfz(filepath.Join(outDir, proructInfzFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Bundles are extracted to a temporary directory, builds are not changed before they are approved
+	extractDir, removeExtractDir, err := createExtractDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer removeExtractDir()
+
+	incomingOpts := opts
+	incomingOpts.ApkDir = dir
+	incomingOpts.Recursive = false
+	incomingOpts.KeepGoing = true
+	apkInfos, failures, err := readApkInfos(incomingOpts, extractDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	apkInfos = sortApkInfos(apkInfos)
+	pending := []pendingPlugin{}
+	for i, apkInfo := range apkInfos {
+		plugin := pendingPlugin{ApkInfo: apkInfo, Problems: []string{}, Warnings: []string{}}
+		// Bundles are approved and rejected as the bundle file
+		if apkInfo.SourcePath != "" && !fileExists(apkInfo.ApkPath) {
+			if bundlePath := findBundleOfApk(apkInfo.ApkPath); bundlePath != "" {
+				plugin.ApkPath = bundlePath
+			}
+		}
+
+		for _, violation := range policy.Check(apkInfo) {
+			if violation.Fatal {
+				plugin.Problems = append(plugin.Problems, violation.Reason)
+			} else {
+				plugin.Warnings = append(plugin.Warnings, violation.Reason)
+			}
+		}
+		if len(opts.Abis) > 0 && !isAbiCompatible(apkInfo, opts.Abis) {
+			plugin.Problems = append(plugin.Problems, "has native libraries only for "+strings.Join(apkInfo.Abis, ", "))
+		}
+
+		// Android installs updates only if they are signed with the same certificate
+		if apkInfo.Platform == "Android" && apkInfo.SignerFingerprint == "" {
+			plugin.Problems = append(plugin.Problems, "is not signed")
+		}
+		for _, existing := range published {
+			if existing.Package != apkInfo.Package || existing.Platform != apkInfo.Platform {
+				continue
+			}
+			if existing.Hash == apkInfo.Hash {
+				plugin.Problems = append(plugin.Problems, "is already published as "+existing.ApkPath)
+				continue
+			}
+			if compareRevisions(existing.Revision, apkInfo.Revision) >= 0 {
+				plugin.Problems = append(plugin.Problems, "revision "+existing.Revision+" is already published")
+			}
+			if apkInfo.SignerFingerprint == "" {
+				continue
+			}
+			existingPath := filepath.Join(outDir, existing.ApkPath)
+			existingInfo, err := readArtifact(artifact.ForFile(existingPath), existingPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading %s: %w", existingPath, err)
+			}
+			if existingInfo.SignerFingerprint != "" && existingInfo.SignerFingerprint != apkInfo.SignerFingerprint {
+				plugin.Problems = append(plugin.Problems, "is signed with another certificate than published revision "+existing.Revision)
+			}
+		}
+
+		// Same build dropped twice
+		for _, other := range apkInfos[:i] {
+			if other.Hash == apkInfo.Hash {
+				plugin.Problems = append(plugin.Problems, "is the same file as "+other.ApkPath)
+				break
+			}
+		}
+
+		pending = append(pending, plugin)
+	}
+
+	return pending, failures, nil
+}
+
+// Check builds in the incoming directory and list them with the problems that prevent approving them.
+// Files are not moved, builds are rejected with pp reject. With JSON option, the builds are printed as JSON.
+func ListPendingPlugins(opts PluginsOptions) error {
+	// Listing does not change the plugins directory, so it does not wait for the lock or recover interrupted packaging
+	pending, failures, err := readPendingPlugins(opts)
+	if err != nil {
+		return err
+	}
+
+	// Files that can not be read are listed with the reason, so that they can be rejected by file name
+	for _, failure := range failures {
+		problem := fmt.Sprintf("can not be read (%s): %v", failure.Stage, failure.Err)
+		pending = append(pending, pendingPlugin{ApkInfo: ApkInfo{ApkPath: failure.Path}, Problems: []string{problem}, Warnings: []string{}})
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(pending, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("No plugins waiting for approval")
+		return nil
+	}
+	for _, plugin := range pending {
+		if plugin.Package == "" {
+			fmt.Println(plugin.ApkPath)
+		} else {
+			fmt.Printf("%s@%s  %s %s  %s\n", plugin.Package, plugin.Revision, plugin.DisplayName, plugin.Version, plugin.ApkPath)
+		}
+		for _, problem := range plugin.Problems {
+			fmt.Println("  Problem:", problem)
+		}
+		for _, warning := range plugin.Warnings {
+			fmt.Println("  Warning:", warning)
+		}
+	}
+	return nil
+}
+
+// Publish a build from the incoming directory, given as PACKAGE@REVISION, and update product.infz.
+// The build is removed from the incoming directory in the same transaction.
+func ApprovePlugin(spec string, opts PluginsOptions) error {
+	unlock, err := lockIncomingDirectories(opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	plugin, err := findPendingPlugin(spec, opts)
+	if err != nil {
+		return err
+	}
+	if len(plugin.Problems) > 0 {
+		return fmt.Errorf("%s can not be approved: %s", spec, strings.Join(plugin.Problems, "; "))
+	}
+
+	err = addPlugin(plugin.ApkPath, opts, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Approved", plugin.DisplayName, "revision", plugin.Revision)
+	return nil
+}
+
+// Move a build from the incoming directory, given as PACKAGE@REVISION or as the file name,
+// to the rejected directory with the reason
+func RejectPlugin(spec, reason string, opts PluginsOptions) error {
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(".")
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	// File name also rejects files that can not be read
+	filePath := path.Join(incomingDir(opts.ApkDir), path.Base(filepath.ToSlash(spec)))
+	if fileInfo, err := os.Stat(filePath); err != nil || fileInfo.IsDir() {
+		plugin, err := findPendingPlugin(spec, opts)
+		if err != nil {
+			return err
+		}
+		filePath = plugin.ApkPath
+	}
+
+	return rejectPlugins(map[string]string{filePath: cmp.Or(reason, "rejected by operator")}, opts)
+}
+
+// Lock the current directory, where the incoming directory is, and the output directory if it is another directory,
+// and recover interrupted transactions in them
+func lockIncomingDirectories(opts PluginsOptions) (func(), error) {
+	dirs := []string{"."}
+	outDir := cmp.Or(opts.OutDir, ".")
+	otherDir, err := isOtherDirectory(outDir)
+	if err != nil {
+		return nil, fmt.Errorf("error checking output directory: %w", err)
+	}
+	if otherDir {
+		dirs = append(dirs, outDir)
+	}
+
+	unlocks := []func(){}
+	unlock := func() {
+		for _, unlock := range slices.Backward(unlocks) {
+			unlock()
+		}
+	}
+	for _, dir := range dirs {
+		unlockDir, err := lockDirectory(dir)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlockDir)
+
+		err = recoverJournal(dir)
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("error recovering interrupted packaging: %w", err)
+		}
+	}
+	return unlock, nil
+}
+
+// Find the build of the package revision, given as PACKAGE@REVISION, in the incoming directory
+func findPendingPlugin(spec string, opts PluginsOptions) (pendingPlugin, error) {
+	packageName, revision, ok := strings.Cut(spec, "@")
+	if !ok || packageName == "" || revision == "" {
+		return pendingPlugin{}, fmt.Errorf("invalid plugin %s, use PACKAGE@REVISION", spec)
+	}
+
+	pending, _, err := readPendingPlugins(opts)
+	if err != nil {
+		return pendingPlugin{}, err
+	}
+	for _, plugin := range pending {
+		if plugin.Package == packageName && plugin.Revision == revision {
+			return plugin, nil
+		}
+	}
+	return pendingPlugin{}, fmt.Errorf("%s is not in %s", spec, incomingDir(opts.ApkDir))
+}
+
+// Move files to the rejected directory in one transaction, each with a file telling the reason
+func rejectPlugins(rejections map[string]string, opts PluginsOptions) error {
+	if len(rejections) == 0 {
+		return nil
+	}
+
+	dir := rejectedDir(opts.ApkDir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	rejected := []string{}
+	err = inTransaction(".", func(tx *transaction) error {
+		// Paths planned in this transaction
+		planned := []ApkInfo{}
+		for _, filePath := range slices.Sorted(maps.Keys(rejections)) {
+			reason := rejections[filePath]
+			rejectedPath, err := freePluginFilePath(path.Join(dir, path.Base(filePath)), planned, nil, ".")
+			if err != nil {
+				return err
+			}
+			planned = append(planned, ApkInfo{ApkPath: rejectedPath})
+
+			err = tx.rename(filePath, rejectedPath)
+			if err != nil {
+				return err
+			}
+			text := fmt.Sprintf("%s rejected %s\n%s\n", path.Base(filePath), time.Now().Format(time.RFC3339), reason)
+			err = tx.writeFile(rejectedPath+rejectionReasonSuffix, writeBytes([]byte(text)))
+			if err != nil {
+				return err
+			}
+			rejected = append(rejected, fmt.Sprintf("Rejected %s: %s", filePath, reason))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, line := range rejected {
+		fmt.Println(line)
+	}
+	return nil
+}
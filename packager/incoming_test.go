@@ -0,0 +1,39 @@
+package packager
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListPendingPluginsReadOnly(t *testing.T) {
+	t.Chdir(t.TempDir())
+	err := os.Mkdir(incomingDirname, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestApk(t, incomingDirname, "new.apk", "com.example.plugin", 1, "Example")
+
+	// Listing does not wait for the lock of packaging in progress, or touch its journal
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	writeTestFiles(t, ".", map[string]string{journalFilename: "{}"})
+
+	done := make(chan error, 1)
+	go func() { done <- ListPendingPlugins(PluginsOptions{NoCache: true}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ListPendingPlugins() waited for the lock")
+	}
+	data, err := os.ReadFile(journalFilename)
+	if err != nil || string(data) != "{}" {
+		t.Errorf("journal = %q, %v, want it unchanged", data, err)
+	}
+}
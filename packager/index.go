@@ -0,0 +1,472 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// Add a package file to product.infz of the output directory without reading the other packages.
+// Older revision of the same plugin is removed. The file is copied to the apk directory, or moved if it is already there.
+func AddPlugin(filePath string, opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	unlock, err := lockDirectory(outDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(outDir)
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	return addPlugin(filePath, opts, false)
+}
+
+// Add a package file or bundle to product.infz with the output directory locked. With removeSource, the file
+// is removed in the same transaction after it has been copied to the apk directory.
+func addPlugin(filePath string, opts PluginsOptions, removeSource bool) error {
+	// Installable apk of a bundle is added
+	readPath := filePath
+	if isBundleFile(filePath) {
+		extractDir, removeExtractDir, err := createExtractDir()
+		if err != nil {
+			return err
+		}
+		defer removeExtractDir()
+
+		readPath, err = extractBundleApk(filePath, extractDir, opts.Abis)
+		if err != nil {
+			return fmt.Errorf("error extracting bundle %s: %w", filePath, err)
+		}
+	}
+
+	reader := artifact.ForFile(readPath)
+	if reader == nil {
+		return fmt.Errorf("%s is not a package file", filePath)
+	}
+
+	policy, err := loadPolicy(opts.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("error loading policy: %w", err)
+	}
+
+	outDir := cmp.Or(opts.OutDir, ".")
+	apkInfo, err := readArtifact(reader, readPath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	if readPath != filePath {
+		// Apk of a bundle is named after the bundle, like when packaging
+		apkInfo.ApkPath = cleanupValue(strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".apk")
+	}
+	if apkInfo.Split != "" {
+		return fmt.Errorf("%s is a split apk and cannot be installed alone", filePath)
+	}
+	if apkInfo.RequiresSplits {
+		return fmt.Errorf("%s requires split apks and cannot be installed via the update server", filePath)
+	}
+	if len(opts.Abis) > 0 && !isAbiCompatible(apkInfo, opts.Abis) {
+		return fmt.Errorf("%s has native libraries only for %s", filePath, strings.Join(apkInfo.Abis, ", "))
+	}
+	err = policy.Enforce([]ApkInfo{apkInfo})
+	if err != nil {
+		return err
+	}
+
+	descriptions, err := loadDescriptions(descriptionsFilename)
+	if err != nil {
+		return err
+	}
+	added := []ApkInfo{apkInfo}
+	applyDescriptions(added, descriptions)
+	apkInfo = added[0]
+
+	// Newer revision of a pinned plugin is held back
+	pins, err := loadPins(opts.PinsFile)
+	if err != nil {
+		return err
+	}
+	if pinnedRevision, ok := pins[apkInfo.Package]; ok && compareRevisions(apkInfo.Revision, pinnedRevision) > 0 {
+		heldPath, err := freePluginFilePath(path.Join(heldDir(opts.ApkDir), path.Base(apkInfo.ApkPath)), nil, nil, outDir)
+		if err != nil {
+			return err
+		}
+		err = inTransaction(outDir, func(tx *transaction) error {
+			targetPath := filepath.Join(outDir, heldPath)
+			_, err := addPluginFile(tx, readPath, targetPath)
+			if err != nil {
+				return err
+			}
+			if removeSource && !isSamePath(filePath, targetPath) {
+				return removePluginFile(tx, filePath)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Holding back", apkInfo.DisplayName, "revision", apkInfo.Revision+", pinned to revision", pinnedRevision+". Kept in", heldPath)
+		return nil
+	}
+
+	apkInfos, err := readProductInfz(filepath.Join(outDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+
+	// Same package is replaced, unless a newer revision is already in the index
+	kept := []ApkInfo{}
+	replaced := []ApkInfo{}
+	for _, existing := range apkInfos {
+		if existing.Package != apkInfo.Package || existing.Platform != apkInfo.Platform {
+			kept = append(kept, existing)
+			continue
+		}
+		if compareRevisions(existing.Revision, apkInfo.Revision) > 0 {
+			return fmt.Errorf("newer revision %s of %s is already in %s", existing.Revision, apkInfo.Package, proructInfzFilename)
+		}
+		replaced = append(replaced, existing)
+	}
+
+	apkInfo.ApkPath = path.Join(filepath.ToSlash(opts.ApkDir), path.Base(apkInfo.ApkPath))
+	newApkInfos := kept
+
+	// Name the file like packaging would with the other plugins of the index, e.g. after the package name if
+	// another plugin has the same label, without taking the file of another plugin in the index
+	if opts.RenamePlugins {
+		newPaths, err := choosePluginFilePaths(append(slices.Clone(kept), apkInfo), false)
+		if err != nil {
+			return err
+		}
+		apkInfo.ApkPath = newPaths[len(newPaths)-1]
+		printPluginNameInUse(apkInfo, apkInfo.ApkPath)
+	}
+	apkInfo.ApkPath, err = freePluginFilePath(apkInfo.ApkPath, newApkInfos, replaced, outDir)
+	if err != nil {
+		return err
+	}
+	apkInfo.SourcePath = readPath
+	newApkInfos = append(newApkInfos, apkInfo)
+
+	err = inTransaction(outDir, func(tx *transaction) error {
+		for _, existing := range replaced {
+			fmt.Println("Replacing", existing.DisplayName, "revision:", existing.Revision)
+			existingPath := filepath.Join(outDir, existing.ApkPath)
+			if isSamePath(existingPath, readPath) {
+				// New version was copied over the old file
+				continue
+			}
+			err := removePluginFile(tx, existingPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Snapshot archives the file from where it is now
+		targetPath := filepath.Join(outDir, apkInfo.ApkPath)
+		sourcePath, err := addPluginFile(tx, readPath, targetPath)
+		if err != nil {
+			return err
+		}
+		newApkInfos[len(newApkInfos)-1].SourcePath = sourcePath
+
+		err = writeIndex(tx, newApkInfos, outDir, opts)
+		if err != nil {
+			return err
+		}
+		if removeSource && !isSamePath(filePath, targetPath) {
+			return removePluginFile(tx, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Added", apkInfo.DisplayName, "revision", apkInfo.Revision, "as", apkInfo.ApkPath)
+	fmt.Println("Package updated:", filepath.Join(outDir, proructInfzFilename))
+	return nil
+}
+
+// Remove a package from product.infz of the output directory and remove its file
+func RemovePlugin(packageName string, opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	unlock, err := lockDirectory(outDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(outDir)
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	apkInfos, err := readProductInfz(filepath.Join(outDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+
+	kept := []ApkInfo{}
+	removed := []ApkInfo{}
+	for _, apkInfo := range apkInfos {
+		if apkInfo.Package == packageName {
+			removed = append(removed, apkInfo)
+		} else {
+			kept = append(kept, apkInfo)
+		}
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("package %s is not in %s", packageName, proructInfzFilename)
+	}
+
+	err = inTransaction(outDir, func(tx *transaction) error {
+		for _, apkInfo := range removed {
+			fmt.Println("Removing", apkInfo.DisplayName, "revision:", apkInfo.Revision)
+			err := removePluginFile(tx, filepath.Join(outDir, apkInfo.ApkPath))
+			if err != nil {
+				return err
+			}
+		}
+		return writeIndex(tx, kept, outDir, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Package updated:", filepath.Join(outDir, proructInfzFilename))
+	return nil
+}
+
+// Write product.infz and SBOM of the packages in the transaction. Details of the packages that were not read
+// are kept from the previous SBOM.
+func writeIndex(tx *transaction, apkInfos []ApkInfo, outDir string, opts PluginsOptions) error {
+	customImagesList, err := checkForCustomImages()
+	if err != nil {
+		return fmt.Errorf("error checking for custom images: %w", err)
+	}
+
+	previousComponents, err := readSbomComponents(filepath.Join(outDir, sbomFilename))
+	if err != nil {
+		return err
+	}
+
+	var productInfz bytes.Buffer
+	err = writeProductInfz(&productInfz, apkInfos, opts.IconDir, customImagesList)
+	if err != nil {
+		return err
+	}
+
+	var sbom bytes.Buffer
+	err = updateSbom(&sbom, apkInfos, previousComponents)
+	if err != nil {
+		return fmt.Errorf("error writing SBOM: %w", err)
+	}
+
+	return writeIndexFiles(tx, apkInfos, outDir, productInfz.Bytes(), sbom.Bytes(), opts)
+}
+
+// Write product.infz and SBOM to the output directory in the transaction, keep a snapshot of them
+// and log the changes to the audit log
+func writeIndexFiles(tx *transaction, apkInfos []ApkInfo, outDir string, productInfz, sbom []byte, opts PluginsOptions) error {
+	// Changes are found by comparing to the previous product.infz, so the log is written first
+	err := writeAuditLog(tx, apkInfos, outDir, opts)
+	if err != nil {
+		return err
+	}
+
+	err = tx.writeFile(filepath.Join(outDir, proructInfzFilename), writeBytes(productInfz))
+	if err != nil {
+		return err
+	}
+
+	err = tx.writeFile(filepath.Join(outDir, sbomFilename), writeBytes(sbom))
+	if err != nil {
+		return fmt.Errorf("error writing SBOM: %w", err)
+	}
+
+	err = writeSnapshot(tx, apkInfos, outDir, productInfz, sbom)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Read packages and their icons from product.infz. Missing product.infz has no packages.
+func readProductInfz(infzPath string) ([]ApkInfo, error) {
+	zipReader, err := zip.OpenReader(infzPath)
+	if os.IsNotExist(err) {
+		return []ApkInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", infzPath, err)
+	}
+	defer zipReader.Close()
+
+	productInf, err := readZipFileByName(&zipReader.Reader, productInfFilename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", productInfFilename, err)
+	}
+	if productInf == nil {
+		return nil, fmt.Errorf("%s does not have %s", infzPath, productInfFilename)
+	}
+
+	apkInfos, err := parseProductInf(string(productInf))
+	if err != nil {
+		return nil, err
+	}
+
+	// Icons are written again with the new product.infz
+	for i, apkInfo := range apkInfos {
+		apkInfos[i].IconData, err = readZipFileByName(&zipReader.Reader, apkInfo.IconPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading icon of %s: %w", apkInfo.DisplayName, err)
+		}
+	}
+
+	return apkInfos, nil
+}
+
+// Parse rows of product.inf created by createProductInf
+func parseProductInf(productInf string) ([]ApkInfo, error) {
+	apkInfos := []ApkInfo{}
+	for i, line := range strings.Split(productInf, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 13 {
+			return nil, fmt.Errorf("error parsing %s line %d: %d fields instead of 13", productInfFilename, i+1, len(fields))
+		}
+		osReq, err := strconv.Atoi(fields[10])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s line %d: %w", productInfFilename, i+1, err)
+		}
+		size, err := strconv.Atoi(fields[12])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s line %d: %w", productInfFilename, i+1, err)
+		}
+
+		apkInfo := ApkInfo{
+			Platform:    fields[0],
+			Type:        fields[1],
+			Package:     fields[2],
+			DisplayName: fields[3],
+			Version:     fields[4],
+			Revision:    fields[5],
+			ApkPath:     fields[6],
+			IconPath:    fields[7],
+			Description: fields[8],
+			Hash:        fields[9],
+			OsReq:       osReq,
+			TakReq:      fields[11],
+			Size:        size,
+		}
+		if reader := artifact.ForFile(apkInfo.ApkPath); reader != nil {
+			apkInfo.Format = reader.Name()
+		}
+		apkInfos = append(apkInfos, apkInfo)
+	}
+	return apkInfos, nil
+}
+
+// Check if the package of the same revision is in the list
+func containsPackage(apkInfos []ApkInfo, apkInfo ApkInfo) bool {
+	for _, other := range apkInfos {
+		if other.Package == apkInfo.Package && other.Platform == apkInfo.Platform && other.Revision == apkInfo.Revision {
+			return true
+		}
+	}
+	return false
+}
+
+// Get a path for the added plugin that is not used by another plugin of the index or another file.
+// Files of the replaced plugins are free to be used. A number is added to the name if the path is taken.
+func freePluginFilePath(apkPath string, apkInfos, replaced []ApkInfo, outDir string) (string, error) {
+	isFree := func(filePath string) (bool, error) {
+		for _, apkInfo := range replaced {
+			if apkInfo.ApkPath == filePath {
+				return true, nil
+			}
+		}
+		for _, apkInfo := range apkInfos {
+			if apkInfo.ApkPath == filePath {
+				return false, nil
+			}
+		}
+		_, err := os.Stat(filepath.Join(outDir, filePath))
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	ext := path.Ext(apkPath)
+	name := strings.TrimSuffix(apkPath, ext)
+	newPath := apkPath
+	for n := 2; ; n++ {
+		free, err := isFree(newPath)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			break
+		}
+		newPath = fmt.Sprintf("%s_%d%s", name, n, ext)
+	}
+
+	if newPath != apkPath {
+		fmt.Println("Plugin file", apkPath, "is already in use, using", newPath)
+	}
+	return newPath, nil
+}
+
+// Check if the paths are the same file path
+func isSamePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// Copy the package file into the repository in the transaction, or move it if it is in the same directory already.
+// Returns the path the file can be read from until the transaction is committed.
+func addPluginFile(tx *transaction, sourcePath, targetPath string) (string, error) {
+	sourceDir, err := filepath.Abs(filepath.Dir(sourcePath))
+	if err != nil {
+		return "", err
+	}
+	targetDir, err := filepath.Abs(filepath.Dir(targetPath))
+	if err != nil {
+		return "", err
+	}
+
+	if sourceDir == targetDir {
+		if filepath.Base(sourcePath) == filepath.Base(targetPath) {
+			return sourcePath, nil
+		}
+		return targetPath, tx.rename(sourcePath, targetPath)
+	}
+
+	return sourcePath, tx.writeFile(targetPath, copyFile(sourcePath))
+}
+
+// Remove the file of a package in the transaction if it exists
+func removePluginFile(tx *transaction, filePath string) error {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+	return tx.remove(filePath)
+}
@@ -0,0 +1,142 @@
+package packager
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/internal/apktest"
+)
+
+// Write apk of the package, revision and label to the directory
+func writeTestApk(t *testing.T, dir, name, packageName string, revision int, label string) {
+	t.Helper()
+	apk := apktest.Build(t, apktest.Manifest(packageName, revision, label), nil)
+	err := os.WriteFile(filepath.Join(dir, name), apk, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Read product.inf of product.infz in the directory
+func readTestProductInf(t *testing.T, dir string) string {
+	t.Helper()
+	zipReader, err := zip.OpenReader(filepath.Join(dir, proructInfzFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipReader.Close()
+	f, err := zipReader.Open(productInfFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Run the function in the directory
+func inTestDir(t *testing.T, dir string, run func() error) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddRemovePluginMatchesPackaging(t *testing.T) {
+	opts := PluginsOptions{RenamePlugins: true, NoCache: true}
+	tests := []struct {
+		name string
+		// Label of the added plugin
+		label string
+	}{
+		{name: "own label", label: "Beta"},
+		{name: "label of another plugin", label: "Alpha"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming := t.TempDir()
+			writeTestApk(t, incoming, "new.apk", "com.example.beta.plugin", 3, tt.label)
+
+			// Plugins added one by one
+			added := t.TempDir()
+			writeTestApk(t, added, "alpha.apk", "com.example.alpha.plugin", 1, "Alpha")
+			inTestDir(t, added, func() error { return PackagePlugins(opts) })
+			withoutAdded := readTestProductInf(t, added)
+			inTestDir(t, added, func() error { return AddPlugin(filepath.Join(incoming, "new.apk"), opts) })
+
+			// Packaging the result again keeps the file of the added plugin
+			repackaged := t.TempDir()
+			writeTestApk(t, repackaged, "alpha.apk", "com.example.alpha.plugin", 1, "Alpha")
+			writeTestApk(t, repackaged, "new.apk", "com.example.beta.plugin", 3, tt.label)
+			inTestDir(t, repackaged, func() error { return PackagePlugins(opts) })
+
+			addedInfos, err := parseProductInf(readTestProductInf(t, added))
+			if err != nil {
+				t.Fatal(err)
+			}
+			packagedInfos, err := parseProductInf(readTestProductInf(t, repackaged))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addedInfos) != 2 || len(packagedInfos) != 2 {
+				t.Fatalf("added %d and packaged %d plugins, want 2", len(addedInfos), len(packagedInfos))
+			}
+			for i, want := range packagedInfos {
+				got := addedInfos[i]
+				if want.Package == "com.example.alpha.plugin" && tt.label == "Alpha" {
+					// File of the plugin in the index is not renamed by pp add
+					continue
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("added plugin = %+v, want %+v", got, want)
+				}
+			}
+
+			// Removing the added plugin restores the index
+			inTestDir(t, added, func() error { return RemovePlugin("com.example.beta.plugin", opts) })
+			got := readTestProductInf(t, added)
+			if got != withoutAdded {
+				t.Errorf("product.inf after pp remove = %q, want %q", got, withoutAdded)
+			}
+		})
+	}
+}
+
+func TestProductInfRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTestApk(t, dir, "alpha.apk", "com.example.alpha.plugin", 1, "Alpha")
+	writeTestApk(t, dir, "beta.apk", "com.example.beta", 20, "Beta app")
+	writeTestApk(t, dir, "same.apk", "com.example.same.plugin", 5, "Alpha")
+	inTestDir(t, dir, func() error { return PackagePlugins(PluginsOptions{RenamePlugins: true, NoCache: true}) })
+	productInf := readTestProductInf(t, dir)
+
+	apkInfos, err := parseProductInf(productInf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apkInfos) != 3 {
+		t.Fatalf("parsed %d plugins, want 3", len(apkInfos))
+	}
+	got := createProductInf(apkInfos)
+	if strings.TrimSpace(got) != strings.TrimSpace(productInf) {
+		t.Errorf("createProductInf(parseProductInf()) = %q, want %q", got, productInf)
+	}
+}
@@ -0,0 +1,405 @@
+package packager
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// Description overrides, used if the file exists in the plugins directory
+const descriptionsFilename = "descriptions.json"
+
+// Limits for reading vendor archives
+const (
+	// Largest file read from an archive
+	ingestMaxFileSize = 1 << 30
+	// Deepest zip inside zips
+	ingestMaxDepth = 3
+	// Longest description taken from release notes
+	maxDescriptionLength = 250
+)
+
+// Description override of a package. Revision limits the override to one revision, empty applies to all.
+type descriptionOverride struct {
+	Revision    string `json:"revision,omitempty"`
+	Description string `json:"description"`
+}
+
+// Load description overrides by package name. If the file does not exist, there are no overrides.
+func loadDescriptions(filePath string) (map[string]descriptionOverride, error) {
+	descriptions := map[string]descriptionOverride{}
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return descriptions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading description file: %w", err)
+	}
+
+	err = json.Unmarshal(data, &descriptions)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing description file %s: %w", filePath, err)
+	}
+	return descriptions, nil
+}
+
+// Replace descriptions of the packages with the overrides
+func applyDescriptions(apkInfos []ApkInfo, descriptions map[string]descriptionOverride) {
+	for i, apkInfo := range apkInfos {
+		override, ok := descriptions[apkInfo.Package]
+		if !ok || (override.Revision != "" && override.Revision != apkInfo.Revision) {
+			continue
+		}
+		apkInfos[i].Description = cleanupValue(override.Description)
+	}
+}
+
+// Package file found in an archive
+type ingestedFile struct {
+	// Path in the archive, nested zips are separated by "!/"
+	archivePath string
+	// File in the temporary directory the package was unpacked to
+	tempPath string
+	// Release notes next to the file or in the directories above it
+	releaseNotes string
+}
+
+// Unpack package files from a vendor archive to the plugins directory, so that they are packaged with the next
+// packaging. Zips inside the archive are searched too, and release notes become description overrides.
+func IngestArchive(archivePath string, opts PluginsOptions) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error opening archive: %w", err)
+	}
+	defer archive.Close()
+
+	// Files are unpacked and checked in a temporary directory before they are placed in the plugins directory
+	tempDir, err := os.MkdirTemp("", "taktool")
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	releaseNotes := map[string]string{}
+	files, err := findArchivePackages(&archive.Reader, "", 0, releaseNotes, tempDir)
+	if err != nil {
+		return fmt.Errorf("error reading archive %s: %w", archivePath, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no package files in archive %s", archivePath)
+	}
+
+	// Use release notes of the nearest directory, or the only release notes of the archive
+	for i, file := range files {
+		for dir := archiveDir(file.archivePath); ; dir = archiveDir(dir) {
+			if notes, ok := releaseNotes[dir]; ok {
+				files[i].releaseNotes = notes
+				break
+			}
+			if dir == "" {
+				break
+			}
+		}
+		if files[i].releaseNotes == "" && len(releaseNotes) == 1 {
+			for _, notes := range releaseNotes {
+				files[i].releaseNotes = notes
+			}
+		}
+	}
+
+	apkInfos := []ApkInfo{}
+	for _, file := range files {
+		apkInfo, err := readArtifact(artifact.ForFile(file.tempPath), file.tempPath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", file.archivePath, err)
+		}
+		apkInfo.SourcePath = file.tempPath
+		apkInfo.ApkPath = path.Join(filepath.ToSlash(opts.ApkDir), archiveFileName(file.archivePath))
+		apkInfos = append(apkInfos, apkInfo)
+	}
+
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(".")
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	// Same content is ingested once, and not at all if it is already in the plugins directory
+	known, err := packageFileHashes(opts)
+	if err != nil {
+		return err
+	}
+	newInfos := []ApkInfo{}
+	newFiles := []ingestedFile{}
+	for i, apkInfo := range apkInfos {
+		if knownAs, ok := known[apkInfo.Hash]; ok {
+			fmt.Println("Skipping", files[i].archivePath+", same file is already", knownAs)
+			continue
+		}
+		known[apkInfo.Hash] = "in the archive as " + files[i].archivePath
+		newInfos = append(newInfos, apkInfo)
+		newFiles = append(newFiles, files[i])
+	}
+	apkInfos, files = newInfos, newFiles
+
+	descriptions, err := loadDescriptions(descriptionsFilename)
+	if err != nil {
+		return err
+	}
+	for i, apkInfo := range apkInfos {
+		// Older revisions in the same archive do not replace the release notes of newer ones
+		if files[i].releaseNotes != "" && !slices.ContainsFunc(apkInfos[:i], func(other ApkInfo) bool {
+			return other.Package == apkInfo.Package && compareRevisions(other.Revision, apkInfo.Revision) > 0
+		}) {
+			descriptions[apkInfo.Package] = descriptionOverride{
+				Revision:    apkInfo.Revision,
+				Description: releaseNotesDescription(files[i].releaseNotes),
+			}
+		}
+	}
+
+	err = inTransaction(".", func(tx *transaction) error {
+		// Paths already planned in this transaction
+		planned := []ApkInfo{}
+		for i, apkInfo := range apkInfos {
+			apkPath, err := freePluginFilePath(apkInfo.ApkPath, planned, nil, ".")
+			if err != nil {
+				return err
+			}
+			err = tx.writeFile(apkPath, copyFile(apkInfo.SourcePath))
+			if err != nil {
+				return err
+			}
+			apkInfos[i].ApkPath = apkPath
+			planned = append(planned, apkInfos[i])
+		}
+
+		data, err := json.MarshalIndent(descriptions, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding descriptions: %w", err)
+		}
+		return tx.writeFile(descriptionsFilename, writeBytes(data))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, apkInfo := range apkInfos {
+		fmt.Println("Ingested", apkInfo.DisplayName, "revision", apkInfo.Revision, "as", apkInfo.ApkPath)
+	}
+	return nil
+}
+
+// Describe the package files of the plugins directory and its held directory by hash
+func packageFileHashes(opts PluginsOptions) (map[string]string, error) {
+	files, err := listPackageFiles(opts.ApkDir, opts.Recursive)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+	if fileExists(heldDir(opts.ApkDir)) {
+		heldFiles, err := listPackageFiles(heldDir(opts.ApkDir), false)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory: %w", err)
+		}
+		files = append(files, heldFiles...)
+	}
+
+	hashes := map[string]string{}
+	for _, filePath := range files {
+		if artifact.ForFile(filePath) == nil {
+			continue
+		}
+		hash, err := calculateHash(filePath)
+		if err != nil {
+			return nil, err
+		}
+		hashes[hash] = "in the plugins directory as " + filePath
+	}
+	return hashes, nil
+}
+
+// Find package files and release notes in the zip. Package files are unpacked to the temporary directory
+// as they are found, and zips inside the zip are searched through a temporary file, so that nothing large is
+// held in memory. Release notes are stored by directory, the root directory is "".
+func findArchivePackages(zipReader *zip.Reader, prefix string, depth int, releaseNotes map[string]string, tempDir string) ([]ingestedFile, error) {
+	if depth > ingestMaxDepth {
+		return nil, fmt.Errorf("zips nested too deep in %s", prefix)
+	}
+
+	// Release notes are read first, so that they are known for all files of the directory
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() || !isReleaseNotesFile(zipFile.Name) {
+			continue
+		}
+		data, err := readArchiveFile(zipFile)
+		if err != nil {
+			return nil, err
+		}
+		releaseNotes[archiveDir(prefix+zipFile.Name)] = string(data)
+	}
+
+	files := []ingestedFile{}
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+		name := prefix + zipFile.Name
+		isZip := strings.EqualFold(path.Ext(zipFile.Name), ".zip")
+		if !isZip && artifact.ForFile(zipFile.Name) == nil {
+			continue
+		}
+
+		// Extension of the temporary file selects the reader
+		tempPath, err := extractArchiveFile(zipFile, tempDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if isZip {
+			nestedFiles, err := findNestedArchivePackages(tempPath, name, depth, releaseNotes, tempDir)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nestedFiles...)
+			continue
+		}
+
+		files = append(files, ingestedFile{archivePath: name, tempPath: tempPath})
+	}
+
+	return files, nil
+}
+
+// Find package files in a zip inside the archive, unpacked to the temporary file. The zip is removed afterwards.
+func findNestedArchivePackages(zipPath, name string, depth int, releaseNotes map[string]string, tempDir string) ([]ingestedFile, error) {
+	defer os.Remove(zipPath)
+
+	nestedZip, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip %s: %w", name, err)
+	}
+	defer nestedZip.Close()
+
+	return findArchivePackages(&nestedZip.Reader, name+"!/", depth+1, releaseNotes, tempDir)
+}
+
+// Name of the file in the archive without directories, safe to use as a file name
+func archiveFileName(archivePath string) string {
+	base := path.Base(strings.ReplaceAll(archivePath, "\\", "/"))
+	ext := path.Ext(base)
+	// Hidden files are not read when packaging
+	name := cleanupValue(strings.TrimLeft(base, "."))
+	if path.Ext(name) != ext || name == ext {
+		name = "plugin" + ext
+	}
+	return name
+}
+
+// Directory of the path in the archive, "" for the root. Directory of a file in a nested zip ends with "!".
+func archiveDir(name string) string {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return ""
+	}
+	return name[:i]
+}
+
+// Read file from an archive, failing if it is larger than the limit
+func readArchiveFile(zipFile *zip.File) ([]byte, error) {
+	if zipFile.UncompressedSize64 > ingestMaxFileSize {
+		return nil, fmt.Errorf("%s is too large", zipFile.Name)
+	}
+
+	rc, err := zipFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", zipFile.Name, err)
+	}
+	defer rc.Close()
+
+	// Size in the zip header can not be trusted
+	data, err := io.ReadAll(io.LimitReader(rc, ingestMaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", zipFile.Name, err)
+	}
+	if len(data) > ingestMaxFileSize {
+		return nil, fmt.Errorf("%s is too large", zipFile.Name)
+	}
+	return data, nil
+}
+
+// Unpack file from an archive to a new file in the directory, failing if it is larger than the limit.
+// Returns the path of the file, which ends with the name of the file in the archive.
+func extractArchiveFile(zipFile *zip.File, dir string) (string, error) {
+	if zipFile.UncompressedSize64 > ingestMaxFileSize {
+		return "", fmt.Errorf("%s is too large", zipFile.Name)
+	}
+
+	rc, err := zipFile.Open()
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", zipFile.Name, err)
+	}
+	defer rc.Close()
+
+	file, err := os.CreateTemp(dir, "*-"+archiveFileName(zipFile.Name))
+	if err != nil {
+		return "", fmt.Errorf("error creating file: %w", err)
+	}
+	defer file.Close()
+
+	// Size in the zip header can not be trusted
+	n, err := io.Copy(file, io.LimitReader(rc, ingestMaxFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("error unpacking %s: %w", zipFile.Name, err)
+	}
+	if n > ingestMaxFileSize {
+		return "", fmt.Errorf("%s is too large", zipFile.Name)
+	}
+	err = file.Close()
+	if err != nil {
+		return "", fmt.Errorf("error writing file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// Check if the file is release notes, e.g. "Release Notes.txt", "RELEASE_NOTES.md" or "changelog.txt"
+func isReleaseNotesFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".txt" && ext != ".md" {
+		return false
+	}
+	base := strings.ToLower(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	base = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(base)
+	return slices.Contains([]string{"releasenotes", "changelog", "changes", "whatsnew"}, base)
+}
+
+// Make a single line description from release notes
+func releaseNotesDescription(notes string) string {
+	lines := []string{}
+	for line := range strings.Lines(notes) {
+		// Leave out markdown heading and list marks
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#*-"))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	description := strings.Join(lines, " ")
+
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		description = string([]rune(description)[:maxDescriptionLength-3]) + "..."
+	}
+	return cleanupValue(description)
+}
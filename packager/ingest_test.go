@@ -0,0 +1,163 @@
+package packager
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/internal/apktest"
+)
+
+// Write the archive of the files outside the working directory and ingest it
+func ingestTestArchive(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "vendor.zip")
+	err := os.WriteFile(archivePath, apktest.Zip(t, files), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = IngestArchive(archivePath, PluginsOptions{NoCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Apk of the package for ingest tests
+func testApkData(t *testing.T, packageName string, revision int) []byte {
+	t.Helper()
+	return apktest.Build(t, apktest.Manifest(packageName, revision, "Example"), nil)
+}
+
+// Check the files of the working directory
+func checkIngestedFiles(t *testing.T, want map[string][]byte) {
+	t.Helper()
+	got := map[string]bool{}
+	err := filepath.WalkDir(".", func(filePath string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") && filePath != descriptionsFilename {
+			got[filePath] = true
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("files = %v, want %d files", got, len(want))
+	}
+	for name, data := range want {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Errorf("%s was not ingested: %v", name, err)
+			continue
+		}
+		if string(content) != string(data) {
+			t.Errorf("%s is not the file of the archive", name)
+		}
+	}
+}
+
+func TestIngestArchive(t *testing.T) {
+	apkA := testApkData(t, "com.example.a", 1)
+	apkB := testApkData(t, "com.example.b", 1)
+	apkC := testApkData(t, "com.example.c", 1)
+	tests := []struct {
+		name     string
+		existing map[string][]byte
+		archive  map[string][]byte
+		want     map[string][]byte
+	}{
+		{
+			name:    "path traversal",
+			archive: map[string][]byte{"../../a.apk": apkA, "/abs/b.apk": apkB, "..\\..\\c.apk": apkC},
+			want:    map[string][]byte{"a.apk": apkA, "b.apk": apkB, "c.apk": apkC},
+		},
+		{
+			name: "nested zips",
+			archive: map[string][]byte{
+				"a.apk":     apkA,
+				"inner.zip": apktest.Zip(t, map[string][]byte{"sub/b.apk": apkB, "deeper.zip": apktest.Zip(t, map[string][]byte{"c.apk": apkC})}),
+			},
+			want: map[string][]byte{"a.apk": apkA, "b.apk": apkB, "c.apk": apkC},
+		},
+		{
+			name:    "duplicate names",
+			archive: map[string][]byte{"v1/plugin.apk": apkA, "v2/plugin.apk": apkB},
+			want:    map[string][]byte{"plugin.apk": apkA, "plugin_2.apk": apkB},
+		},
+		{
+			name:    "same content in the archive",
+			archive: map[string][]byte{"a.apk": apkA, "copy/a-copy.apk": apkA, "b.apk": apkB},
+			want:    map[string][]byte{"a.apk": apkA, "b.apk": apkB},
+		},
+		{
+			name:     "same content in the plugins directory",
+			existing: map[string][]byte{"old.apk": apkA, filepath.Join(heldDirname, "held.apk"): apkB},
+			archive:  map[string][]byte{"a.apk": apkA, "b.apk": apkB, "c.apk": apkC},
+			want:     map[string][]byte{"old.apk": apkA, filepath.Join(heldDirname, "held.apk"): apkB, "c.apk": apkC},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			t.Chdir(root)
+			err := os.Mkdir("plugins", 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Chdir("plugins")
+			for name, data := range tt.existing {
+				err := os.MkdirAll(filepath.Dir(name), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(name, data, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ingestTestArchive(t, tt.archive)
+			checkIngestedFiles(t, tt.want)
+
+			// Nothing is written outside the plugins directory
+			entries, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("files written outside the plugins directory: %v", entries)
+			}
+		})
+	}
+}
+
+func TestIngestArchiveReleaseNotes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ingestTestArchive(t, map[string][]byte{
+		"RELEASE_NOTES.md":       []byte("# Vendor release\n"),
+		"a/a.apk":                testApkData(t, "com.example.a", 1),
+		"b/b.apk":                testApkData(t, "com.example.b", 2),
+		"b/changelog.txt":        []byte("# Version 2\n- Fixed the map\n- Faster sync\n"),
+		"c/old/c.apk":            testApkData(t, "com.example.c", 1),
+		"c/new/c.apk":            testApkData(t, "com.example.c", 2),
+		"c/new/whatsnew.txt":     []byte("Newer"),
+		"c/old/Release Notes.md": []byte("Older"),
+	})
+
+	descriptions, err := loadDescriptions(descriptionsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]descriptionOverride{
+		"com.example.a": {Revision: "1", Description: "Vendor release"},
+		"com.example.b": {Revision: "2", Description: "Version 2 Fixed the map Faster sync"},
+		"com.example.c": {Revision: "2", Description: "Newer"},
+	}
+	for packageName, override := range want {
+		if descriptions[packageName] != override {
+			t.Errorf("description of %s = %+v, want %+v", packageName, descriptions[packageName], override)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Check if the file is an iOS app archive
+func isIpaFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".ipa")
+}
+
+// Read app information from iOS app archive (.ipa)
+func getIpaData(ipaPath string) (ApkInfo, error) {
+	zipReader, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("failed to open the IPA: %w", err)
+	}
+	defer zipReader.Close()
+
+	// Info.plist is in the app bundle directory Payload/<name>.app/
+	var infoPlistFile *zip.File
+	for _, zipFile := range zipReader.File {
+		parts := strings.Split(zipFile.Name, "/")
+		if len(parts) == 3 && parts[0] == "Payload" && strings.HasSuffix(parts[1], ".app") && parts[2] == "Info.plist" {
+			infoPlistFile = zipFile
+			break
+		}
+	}
+	if infoPlistFile == nil {
+		return ApkInfo{}, errors.New("failed to find Payload/*.app/Info.plist in IPA")
+	}
+
+	data, err := readZipFile(infoPlistFile)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+	plist, err := parsePlist(data)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("failed to parse Info.plist: %w", err)
+	}
+	infoPlist, ok := plist.(map[string]any)
+	if !ok {
+		return ApkInfo{}, errors.New("Info.plist is not a dictionary")
+	}
+
+	ipaData := ApkInfo{
+		Platform:    "iOS",
+		Type:        "app",
+		Package:     cleanupValue(plistString(infoPlist, "CFBundleIdentifier")),
+		DisplayName: cleanupValue(plistString(infoPlist, "CFBundleDisplayName")),
+		Version:     cleanupValue(plistString(infoPlist, "CFBundleShortVersionString")),
+		Revision:    cleanupValue(plistString(infoPlist, "CFBundleVersion")),
+		OsReq:       1,
+	}
+	if ipaData.DisplayName == "" {
+		ipaData.DisplayName = cleanupValue(plistString(infoPlist, "CFBundleName"))
+	}
+
+	// OS requirement is the major version of the minimum iOS version
+	minimumOSVersion, _, _ := strings.Cut(plistString(infoPlist, "MinimumOSVersion"), ".")
+	if osReq, err := strconv.Atoi(minimumOSVersion); err == nil {
+		ipaData.OsReq = osReq
+	}
+
+	// Use the largest png icon of the app
+	ipaData.IconPath = findIpaIcon(zipReader.File, path.Dir(infoPlistFile.Name), ipaIconNames(infoPlist))
+
+	return ipaData, nil
+}
+
+// Get icon file names from Info.plist. Names are without the size and extension suffixes, e.g. "AppIcon60x60".
+func ipaIconNames(infoPlist map[string]any) []string {
+	names := []string{}
+
+	addNames := func(value any) {
+		files, _ := value.([]any)
+		for _, file := range files {
+			if name, ok := file.(string); ok {
+				names = append(names, strings.TrimSuffix(name, ".png"))
+			}
+		}
+	}
+
+	for _, key := range []string{"CFBundleIcons", "CFBundleIcons~ipad"} {
+		icons, _ := infoPlist[key].(map[string]any)
+		primaryIcon, _ := icons["CFBundlePrimaryIcon"].(map[string]any)
+		addNames(primaryIcon["CFBundleIconFiles"])
+	}
+	addNames(infoPlist["CFBundleIconFiles"])
+	if name := plistString(infoPlist, "CFBundleIconFile"); name != "" {
+		names = append(names, strings.TrimSuffix(name, ".png"))
+	}
+
+	return names
+}
+
+// Find the largest png file in the app directory matching one of the icon names
+func findIpaIcon(files []*zip.File, appDir string, iconNames []string) string {
+	iconPath := ""
+	var iconSize uint64
+
+	for _, zipFile := range files {
+		if path.Dir(zipFile.Name) != appDir || !strings.HasSuffix(zipFile.Name, ".png") {
+			continue
+		}
+		for _, name := range iconNames {
+			if strings.HasPrefix(path.Base(zipFile.Name), name) && zipFile.UncompressedSize64 > iconSize {
+				iconPath = zipFile.Name
+				iconSize = zipFile.UncompressedSize64
+			}
+		}
+	}
+
+	return iconPath
+}
+
+// Convert png optimized by Xcode (CgBI chunk, BGRA with premultiplied alpha and raw deflate data)
+// to a standard png. Other pngs are returned as is.
+func normalizeIosPng(data []byte) ([]byte, error) {
+	pngSignature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, pngSignature) || len(data) < 16 || string(data[12:16]) != "CgBI" {
+		return data, nil
+	}
+
+	var width, height int
+	var idat bytes.Buffer
+
+	// Read chunks until the end chunk
+	pos := len(pngSignature)
+chunks:
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			return nil, errors.New("invalid png chunk")
+		}
+		chunk := data[pos+8 : pos+8+length]
+		pos += 12 + length
+
+		switch chunkType {
+		case "IHDR":
+			if length < 13 {
+				return nil, errors.New("invalid png header")
+			}
+			width = int(binary.BigEndian.Uint32(chunk[0:4]))
+			height = int(binary.BigEndian.Uint32(chunk[4:8]))
+			// Xcode writes 8-bit RGBA only
+			if chunk[8] != 8 || chunk[9] != 6 || chunk[12] != 0 {
+				return nil, errors.New("unsupported CgBI png format")
+			}
+		case "IDAT":
+			idat.Write(chunk)
+		case "IEND":
+			break chunks
+		}
+	}
+
+	if width <= 0 || height <= 0 || width > 4096 || height > 4096 {
+		return nil, errors.New("invalid png size")
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(flate.NewReader(&idat), int64(height*(width*4+1))))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing png data: %w", err)
+	}
+
+	img, err := unfilterPng(raw, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	// Swap BGRA to RGBA and undo alpha premultiplication
+	for i := 0; i < len(img.Pix); i += 4 {
+		b, g, r, a := img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
+		if a > 0 && a < 255 {
+			r = uint8(min(255, int(r)*255/int(a)))
+			g = uint8(min(255, int(g)*255/int(a)))
+			b = uint8(min(255, int(b)*255/int(a)))
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = r, g, b, a
+	}
+
+	var result bytes.Buffer
+	if err := png.Encode(&result, img); err != nil {
+		return nil, fmt.Errorf("error encoding png: %w", err)
+	}
+	return result.Bytes(), nil
+}
+
+// Reverse png scanline filters of 8-bit 4 channel image data
+func unfilterPng(raw []byte, width, height int) (*image.NRGBA, error) {
+	const bpp = 4
+	stride := width * bpp
+	if len(raw) < height*(stride+1) {
+		return nil, errors.New("png data too short")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	prev := make([]byte, stride)
+
+	for y := 0; y < height; y++ {
+		filter := raw[y*(stride+1)]
+		line := raw[y*(stride+1)+1 : (y+1)*(stride+1)]
+		cur := img.Pix[y*img.Stride : y*img.Stride+stride]
+
+		for x := 0; x < stride; x++ {
+			var left, upLeft byte
+			if x >= bpp {
+				left = cur[x-bpp]
+				upLeft = prev[x-bpp]
+			}
+			up := prev[x]
+
+			switch filter {
+			case 0:
+				cur[x] = line[x]
+			case 1:
+				cur[x] = line[x] + left
+			case 2:
+				cur[x] = line[x] + up
+			case 3:
+				cur[x] = line[x] + byte((int(left)+int(up))/2)
+			case 4:
+				cur[x] = line[x] + paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("unknown png filter %d", filter)
+			}
+		}
+		prev = cur
+	}
+
+	return img, nil
+}
+
+// Paeth predictor of png filter type 4
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := abs(p - int(a))
+	pb := abs(p - int(b))
+	pc := abs(p - int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,172 @@
+package packager
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// Stored pixels of the CgBI fixture, BGRA with premultiplied alpha
+var cgbiPixels = [][]byte{
+	{0, 0, 255, 255, 25, 50, 100, 128},
+	{0, 0, 0, 0, 30, 20, 10, 255},
+}
+
+// Pixels of the CgBI fixture after normalizing
+var cgbiWant = []color.NRGBA{
+	{255, 0, 0, 255}, {199, 99, 49, 128},
+	{0, 0, 0, 0}, {10, 20, 30, 255},
+}
+
+// Build Xcode optimized png of the scanlines with the filter. Image data is compressed without zlib header.
+func buildCgbiPng(t *testing.T, colorType byte, filter byte, lines [][]byte) []byte {
+	t.Helper()
+	data := []byte("\x89PNG\r\n\x1a\n")
+	addChunk := func(chunkType string, chunk []byte) {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(chunk)))
+		start := len(data)
+		data = append(data, chunkType...)
+		data = append(data, chunk...)
+		data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data[start:]))
+	}
+
+	const bpp = 4
+	raw := []byte{}
+	prev := make([]byte, len(lines[0]))
+	for _, line := range lines {
+		raw = append(raw, filter)
+		for x, c := range line {
+			var left, upLeft byte
+			if x >= bpp {
+				left = line[x-bpp]
+				upLeft = prev[x-bpp]
+			}
+			up := prev[x]
+			switch filter {
+			case 1:
+				c -= left
+			case 2:
+				c -= up
+			case 3:
+				c -= byte((int(left) + int(up)) / 2)
+			case 4:
+				c -= paeth(left, up, upLeft)
+			}
+			raw = append(raw, c)
+		}
+		prev = line
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(raw)
+	w.Close()
+
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(lines[0])/bpp))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(lines)))
+	header = append(header, 8, colorType, 0, 0, 0)
+
+	addChunk("CgBI", []byte{0x50, 0x00, 0x20, 0x06})
+	addChunk("IHDR", header)
+	// Image data may be split in several chunks
+	half := compressed.Len() / 2
+	addChunk("IDAT", compressed.Bytes()[:half])
+	addChunk("IDAT", compressed.Bytes()[half:])
+	addChunk("IEND", nil)
+	return data
+}
+
+func TestNormalizeIosPng(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter byte
+	}{
+		{name: "none", filter: 0},
+		{name: "sub", filter: 1},
+		{name: "up", filter: 2},
+		{name: "average", filter: 3},
+		{name: "paeth", filter: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := normalizeIosPng(buildCgbiPng(t, 6, tt.filter, cgbiPixels))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != image.Rect(0, 0, 2, 2) {
+				t.Fatalf("bounds = %v, want 2x2", img.Bounds())
+			}
+			for i, want := range cgbiWant {
+				got := color.NRGBAModel.Convert(img.At(i%2, i/2)).(color.NRGBA)
+				if got != want {
+					t.Errorf("pixel %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeIosPngStandard(t *testing.T) {
+	var standard bytes.Buffer
+	err := png.Encode(&standard, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := normalizeIosPng(standard.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, standard.Bytes()) {
+		t.Error("standard png was changed")
+	}
+}
+
+func TestNormalizeIosPngErrors(t *testing.T) {
+	valid := buildCgbiPng(t, 6, 0, cgbiPixels)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "color type",
+			data: buildCgbiPng(t, 2, 0, cgbiPixels),
+			want: "unsupported CgBI png format",
+		},
+		{
+			name: "size",
+			data: buildCgbiPng(t, 6, 0, [][]byte{{}}),
+			want: "invalid png size",
+		},
+		{
+			name: "filter",
+			data: buildCgbiPng(t, 6, 5, cgbiPixels),
+			want: "unknown png filter 5",
+		},
+		{
+			name: "truncated chunk",
+			data: valid[:40],
+			want: "invalid png chunk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := normalizeIosPng(tt.data)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("normalizeIosPng() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Lock file of the output directory, so that only one taktool changes the repository at a time
+const lockFilename = ".taktool-lock"
+
+// Time to wait for another taktool to finish
+const lockTimeout = 5 * time.Minute
+
+// Owner of a lock, written to the lock file
+type lockOwner struct {
+	Pid  int    `json:"pid"`
+	Host string `json:"host"`
+}
+
+// Lock the directory, waiting while another taktool has it locked. Lock of a taktool that is no longer running
+// on this host is taken over. Returned function releases the lock.
+func lockDirectory(dir string) (func(), error) {
+	lockPath := filepath.Join(dir, lockFilename)
+	deadline := time.Now().Add(lockTimeout)
+	waiting := false
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error getting host name: %w", err)
+	}
+	owner := lockOwner{Pid: os.Getpid(), Host: hostname}
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding lock file: %w", err)
+	}
+
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			// Process id and host tell who has the lock, and if the lock is stale
+			_, err = file.Write(data)
+			file.Close()
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, fmt.Errorf("error writing lock file: %w", err)
+			}
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lock file: %w", err)
+		}
+
+		tookOver, err := takeOverStaleLock(lockPath, hostname)
+		if err != nil {
+			return nil, err
+		}
+		if tookOver {
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("directory is locked by %s, remove %s if no other taktool is running", describeLockOwner(lockPath), lockPath)
+		}
+		if !waiting {
+			fmt.Println("Waiting for another taktool to finish")
+			waiting = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Read the owner of the lock. Lock files of older versions and lock files that are still being written have no owner.
+func readLockOwner(lockPath string) (lockOwner, bool) {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return lockOwner{}, false
+	}
+	owner := lockOwner{}
+	if json.Unmarshal(data, &owner) != nil || owner.Pid == 0 || owner.Host == "" {
+		return lockOwner{}, false
+	}
+	return owner, true
+}
+
+// Remove the lock if its taktool is no longer running on this host. Locks of other hosts are never removed,
+// because their processes can not be checked. Only one taktool checks the lock at a time, so that a new lock
+// taken by another taktool is not removed.
+func takeOverStaleLock(lockPath, hostname string) (bool, error) {
+	owner, ok := readLockOwner(lockPath)
+	if !ok || owner.Host != hostname || isProcessRunning(owner.Pid) {
+		return false, nil
+	}
+
+	takeoverPath := lockPath + ".takeover"
+	file, err := os.OpenFile(takeoverPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		// Another taktool is taking over the lock
+		return false, nil
+	}
+	file.Close()
+	defer os.Remove(takeoverPath)
+
+	// Lock may have been taken over and taken again since it was read
+	current, ok := readLockOwner(lockPath)
+	if !ok || current != owner {
+		return false, nil
+	}
+	fmt.Println("Taking over lock of taktool process", owner.Pid, "that is no longer running")
+	err = os.Remove(lockPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("error removing stale lock file: %w", err)
+	}
+	return true, nil
+}
+
+// Describe the taktool that has the lock for error messages
+func describeLockOwner(lockPath string) string {
+	owner, ok := readLockOwner(lockPath)
+	if !ok {
+		return "another taktool"
+	}
+	return fmt.Sprintf("taktool process %d on %s", owner.Pid, owner.Host)
+}
@@ -0,0 +1,368 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Check if the file is a Windows installer
+func isMsiFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".msi")
+}
+
+// Windows installer database tables and streams
+type msiDatabase struct {
+	cf *compoundFile
+	// Raw stream names by decoded name, tables are prefixed with "!"
+	streamNames map[string]string
+	strings     []string
+	// String references are 3 bytes instead of 2 in large databases
+	stringRefSize int
+}
+
+// Read WinTAK plugin information from Windows installer (.msi)
+func getMsiData(msiPath string) (ApkInfo, error) {
+	db, err := openMsiDatabase(msiPath)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("failed to open the MSI: %w", err)
+	}
+
+	properties, err := db.readProperties()
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("failed to read MSI properties: %w", err)
+	}
+
+	msiData := ApkInfo{
+		Platform:    "Windows",
+		Type:        "plugin",
+		DisplayName: cleanupValue(properties["ProductName"]),
+		Version:     cleanupValue(properties["ProductVersion"]),
+		Vendor:      cleanupValue(properties["Manufacturer"]),
+		Description: cleanupValue(properties["ARPCOMMENTS"]),
+		OsReq:       1,
+	}
+
+	// Upgrade code stays the same across versions of the product
+	msiData.Package = cleanupValue(properties["UpgradeCode"])
+	if msiData.Package == "" {
+		msiData.Package = cleanupValue(properties["ProductCode"])
+	}
+	if msiData.Package == "" {
+		return ApkInfo{}, errors.New("MSI has no UpgradeCode or ProductCode property")
+	}
+	if msiData.Description == "" {
+		msiData.Description = msiData.Vendor
+	}
+
+	msiData.Revision, err = msiVersionToRevision(msiData.Version)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+
+	return msiData, nil
+}
+
+// Read the icon shown in Add/Remove Programs as png, returns nil if the installer has no icon
+func getMsiIcon(msiPath string) ([]byte, error) {
+	db, err := openMsiDatabase(msiPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the MSI: %w", err)
+	}
+
+	properties, err := db.readProperties()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MSI properties: %w", err)
+	}
+
+	iconName := properties["ARPPRODUCTICON"]
+	if iconName == "" {
+		return nil, nil
+	}
+	iconData, err := db.readStream("Icon." + iconName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading icon %s: %w", iconName, err)
+	}
+	pngData, err := icoToPng(iconData)
+	if err != nil {
+		fmt.Println("Package", cleanupValue(properties["ProductName"]), "icon could not be read:", err)
+		return nil, nil
+	}
+	return pngData, nil
+}
+
+// Convert MSI product version major.minor.build to an integer revision
+func msiVersionToRevision(version string) (string, error) {
+	parts := strings.Split(version, ".")
+	limits := []int{255, 255, 65535}
+	numbers := []int{0, 0, 0}
+	for i, limit := range limits {
+		if i >= len(parts) {
+			break
+		}
+		number, err := strconv.Atoi(parts[i])
+		if err != nil || number < 0 || number > limit {
+			return "", fmt.Errorf("invalid MSI product version %q", version)
+		}
+		numbers[i] = number
+	}
+	return strconv.Itoa(numbers[0]<<24 | numbers[1]<<16 | numbers[2]), nil
+}
+
+// Open MSI database and read its string pool
+func openMsiDatabase(msiPath string) (*msiDatabase, error) {
+	cf, err := openCompoundFile(msiPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &msiDatabase{
+		cf:          cf,
+		streamNames: map[string]string{},
+	}
+	for rawName := range cf.streams {
+		db.streamNames[decodeMsiStreamName(rawName)] = rawName
+	}
+
+	err = db.readStringPool()
+	if err != nil {
+		return nil, fmt.Errorf("error reading string pool: %w", err)
+	}
+
+	return db, nil
+}
+
+// Decode MSI stream name. Characters are packed two per UTF-16 code unit, table names start with 0x4840.
+func decodeMsiStreamName(rawName string) string {
+	var name strings.Builder
+	for _, c := range rawName {
+		switch {
+		case c == 0x4840:
+			name.WriteByte('!')
+		case c >= 0x3800 && c < 0x4800:
+			c -= 0x3800
+			name.WriteByte(msiNameChar(c & 0x3f))
+			name.WriteByte(msiNameChar((c >> 6) & 0x3f))
+		case c >= 0x4800 && c < 0x4840:
+			name.WriteByte(msiNameChar(c - 0x4800))
+		default:
+			name.WriteRune(c)
+		}
+	}
+	return name.String()
+}
+
+// Map 6-bit value to a stream name character
+func msiNameChar(c rune) byte {
+	switch {
+	case c < 10:
+		return byte('0' + c)
+	case c < 36:
+		return byte('A' + c - 10)
+	case c < 62:
+		return byte('a' + c - 36)
+	case c == 62:
+		return '.'
+	}
+	return '_'
+}
+
+// Read stream by decoded name
+func (db *msiDatabase) readStream(name string) ([]byte, error) {
+	rawName, ok := db.streamNames[name]
+	if !ok {
+		return nil, fmt.Errorf("stream %s not found", name)
+	}
+	return db.cf.readStream(rawName)
+}
+
+// Read all strings of the database. String ids start from 1.
+func (db *msiDatabase) readStringPool() error {
+	pool, err := db.readStream("!_StringPool")
+	if err != nil {
+		return err
+	}
+	data, err := db.readStream("!_StringData")
+	if err != nil {
+		return err
+	}
+	if len(pool) < 4 {
+		return errors.New("string pool too short")
+	}
+
+	codepage := binary.LittleEndian.Uint32(pool[0:4])
+	db.stringRefSize = 2
+	if codepage&0x80000000 != 0 {
+		db.stringRefSize = 3
+	}
+
+	// Each string has a length and a reference count, id 0 is the empty string
+	db.strings = []string{""}
+	offset := 0
+	count := len(pool) / 4
+	for i := 1; i < count; {
+		length := int(binary.LittleEndian.Uint16(pool[i*4:]))
+		refs := binary.LittleEndian.Uint16(pool[i*4+2:])
+
+		if length == 0 && refs == 0 {
+			// Unused id
+			db.strings = append(db.strings, "")
+			i++
+			continue
+		}
+		if length == 0 {
+			// Strings longer than 64k use the next entry for the length
+			if (i+1)*4+4 > len(pool) {
+				return errors.New("invalid long string in string pool")
+			}
+			length = int(binary.LittleEndian.Uint32(pool[(i+1)*4:]))
+			i += 2
+		} else {
+			i++
+		}
+
+		if offset+length > len(data) {
+			return errors.New("string data too short")
+		}
+		db.strings = append(db.strings, decodeMsiString(data[offset:offset+length]))
+		offset += length
+	}
+
+	return nil
+}
+
+// Decode string from the string pool. Strings are usually UTF-8 or Windows-1252.
+func decodeMsiString(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// Get string by string reference
+func (db *msiDatabase) stringAt(data []byte) string {
+	id := int(data[0]) | int(data[1])<<8
+	if db.stringRefSize == 3 {
+		id |= int(data[2]) << 16
+	}
+	if id >= len(db.strings) {
+		return ""
+	}
+	return db.strings[id]
+}
+
+// Read the Property table. It has two string columns, Property and Value, stored column by column.
+func (db *msiDatabase) readProperties() (map[string]string, error) {
+	table, err := db.readStream("!Property")
+	if err != nil {
+		return nil, err
+	}
+
+	rowSize := 2 * db.stringRefSize
+	rows := len(table) / rowSize
+	properties := map[string]string{}
+	for row := 0; row < rows; row++ {
+		name := db.stringAt(table[row*db.stringRefSize:])
+		value := db.stringAt(table[(rows+row)*db.stringRefSize:])
+		properties[name] = value
+	}
+
+	return properties, nil
+}
+
+// Get the largest image of an icon file as png. PNG images are returned as is, 24 and 32-bit bitmaps are converted.
+func icoToPng(data []byte) ([]byte, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[0:2]) != 0 || binary.LittleEndian.Uint16(data[2:4]) != 1 {
+		return nil, errors.New("not an icon file")
+	}
+
+	// Find the largest image
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	var largestImage []byte
+	largest := 0
+	for i := 0; i < count; i++ {
+		entry := 6 + i*16
+		if entry+16 > len(data) {
+			return nil, errors.New("icon directory too short")
+		}
+		// Size 0 means 256 pixels
+		width := int(data[entry])
+		if width == 0 {
+			width = 256
+		}
+		size := int(binary.LittleEndian.Uint32(data[entry+8:]))
+		offset := int(binary.LittleEndian.Uint32(data[entry+12:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("icon image out of bounds")
+		}
+		if width > largest {
+			largest = width
+			largestImage = data[offset : offset+size]
+		}
+	}
+	if largestImage == nil {
+		return nil, errors.New("icon has no images")
+	}
+
+	if bytes.HasPrefix(largestImage, []byte("\x89PNG")) {
+		return largestImage, nil
+	}
+
+	return bitmapToPng(largestImage)
+}
+
+// Convert icon bitmap (BITMAPINFOHEADER and bottom-up pixels, height includes the mask) to png
+func bitmapToPng(data []byte) ([]byte, error) {
+	if len(data) < 40 {
+		return nil, errors.New("icon bitmap too short")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2
+	bitCount := int(binary.LittleEndian.Uint16(data[14:16]))
+
+	if bitCount != 24 && bitCount != 32 {
+		return nil, fmt.Errorf("unsupported icon bitmap with %d bits per pixel", bitCount)
+	}
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, errors.New("invalid icon bitmap size")
+	}
+
+	bytesPerPixel := bitCount / 8
+	// Rows are padded to 4 bytes
+	stride := (width*bytesPerPixel + 3) &^ 3
+	pixels := data[headerSize:]
+	if len(pixels) < stride*height {
+		return nil, errors.New("icon bitmap data too short")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*bytesPerPixel:]
+			alpha := uint8(255)
+			if bytesPerPixel == 4 {
+				alpha = p[3]
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: p[2], G: p[1], B: p[0], A: alpha})
+		}
+	}
+
+	var result bytes.Buffer
+	if err := png.Encode(&result, img); err != nil {
+		return nil, fmt.Errorf("error encoding png: %w", err)
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,257 @@
+package packager
+
+import (
+	"encoding/binary"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Encode MSI stream name, tables are prefixed with "!"
+func encodeMsiStreamName(name string) string {
+	const chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz._"
+	encoded := []rune{}
+	if table, ok := strings.CutPrefix(name, "!"); ok {
+		encoded = append(encoded, 0x4840)
+		name = table
+	}
+	for i := 0; i < len(name); i += 2 {
+		c1 := rune(strings.IndexByte(chars, name[i]))
+		if i+1 == len(name) {
+			encoded = append(encoded, 0x4800+c1)
+			break
+		}
+		c2 := rune(strings.IndexByte(chars, name[i+1]))
+		encoded = append(encoded, 0x3800+c1+c2<<6)
+	}
+	return string(encoded)
+}
+
+// String pool and string data of the strings. Empty strings are unused ids, strings longer than 64k use two entries.
+func msiStringPool(codepage uint32, strs []string) ([]byte, []byte) {
+	pool := binary.LittleEndian.AppendUint32(nil, codepage)
+	data := []byte{}
+	for _, s := range strs {
+		switch {
+		case s == "":
+			pool = binary.LittleEndian.AppendUint32(pool, 0)
+		case len(s) > 0xFFFF:
+			pool = binary.LittleEndian.AppendUint16(pool, 0)
+			pool = binary.LittleEndian.AppendUint16(pool, 1)
+			pool = binary.LittleEndian.AppendUint32(pool, uint32(len(s)))
+		default:
+			pool = binary.LittleEndian.AppendUint16(pool, uint16(len(s)))
+			pool = binary.LittleEndian.AppendUint16(pool, 1)
+		}
+		data = append(data, s...)
+	}
+	return pool, data
+}
+
+// Property table of the properties with string ids from the strings, stored column by column
+func msiPropertyTable(strs []string, refSize int, properties [][2]string) []byte {
+	table := []byte{}
+	for column := range 2 {
+		for _, property := range properties {
+			id := slices.Index(strs, property[column]) + 1
+			table = binary.LittleEndian.AppendUint32(table, uint32(id))[:len(table)+refSize]
+		}
+	}
+	return table
+}
+
+// Write MSI file with the streams by decoded name
+func writeMsiFile(t *testing.T, streams map[string][]byte) string {
+	t.Helper()
+	cfbStreams := []testStream{}
+	for _, name := range slices.Sorted(maps.Keys(streams)) {
+		cfbStreams = append(cfbStreams, testStream{name: encodeMsiStreamName(name), data: streams[name]})
+	}
+	msiPath := filepath.Join(t.TempDir(), "test.msi")
+	err := os.WriteFile(msiPath, buildCompoundFile(cfbStreams), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msiPath
+}
+
+func TestDecodeMsiStreamName(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "䡀㼿䕷䑬㹪䒲䠯", want: "!_StringPool"},
+		{raw: "䡀䕙䓲䕨䜷", want: "!Property"},
+		{raw: "䆒䑲䓾䒵䞧䆬䠲", want: "Icon.prod.ico"},
+		{raw: "䠤", want: "a"},
+		{raw: "\u0005SummaryInformation", want: "\u0005SummaryInformation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := decodeMsiStreamName(tt.raw); got != tt.want {
+				t.Errorf("decodeMsiStreamName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsiStringPool(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	tests := []struct {
+		name     string
+		codepage uint32
+		strings  []string
+		refSize  int
+	}{
+		{
+			name:     "utf-8",
+			codepage: 65001,
+			strings:  []string{"ProductName", "Hello", "ProductVersion", "1.2.3"},
+			refSize:  2,
+		},
+		{
+			name:     "unused id",
+			codepage: 65001,
+			strings:  []string{"ProductName", "", "Hello"},
+			refSize:  2,
+		},
+		{
+			name:     "long string",
+			codepage: 65001,
+			strings:  []string{"ProductName", long, "Hello"},
+			refSize:  2,
+		},
+		{
+			name:     "large database",
+			codepage: 65001 | 0x80000000,
+			strings:  []string{"ProductName", "Hello"},
+			refSize:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, data := msiStringPool(tt.codepage, tt.strings)
+			db, err := openMsiDatabase(writeMsiFile(t, map[string][]byte{"!_StringPool": pool, "!_StringData": data}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := slices.Concat([]string{""}, tt.strings)
+			if !slices.Equal(db.strings, want) {
+				t.Errorf("strings = %.40q, want %.40q", db.strings, want)
+			}
+			if db.stringRefSize != tt.refSize {
+				t.Errorf("stringRefSize = %d, want %d", db.stringRefSize, tt.refSize)
+			}
+		})
+	}
+}
+
+func TestMsiStringPoolErrors(t *testing.T) {
+	pool, data := msiStringPool(65001, []string{"ProductName", "Hello"})
+	tests := []struct {
+		name string
+		pool []byte
+		data []byte
+		want string
+	}{
+		{
+			name: "pool too short",
+			pool: pool[:2],
+			data: data,
+			want: "error reading string pool: string pool too short",
+		},
+		{
+			name: "data too short",
+			pool: pool,
+			data: data[:12],
+			want: "error reading string pool: string data too short",
+		},
+		{
+			name: "long string without length",
+			pool: binary.LittleEndian.AppendUint32(pool, 1<<16),
+			data: data,
+			want: "error reading string pool: invalid long string in string pool",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openMsiDatabase(writeMsiFile(t, map[string][]byte{"!_StringPool": tt.pool, "!_StringData": tt.data}))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("openMsiDatabase() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsiProperties(t *testing.T) {
+	properties := [][2]string{
+		{"ProductName", "Hello"},
+		{"ProductVersion", "1.2.3"},
+		{"Manufacturer", "caf\xe9"},
+	}
+	want := map[string]string{
+		"ProductName":    "Hello",
+		"ProductVersion": "1.2.3",
+		"Manufacturer":   "café",
+	}
+	strs := []string{"ProductName", "Hello", "ProductVersion", "1.2.3", "Manufacturer", "caf\xe9"}
+	// Ids of large databases do not fit in 2 bytes
+	largeStrs := slices.Concat(make([]string, 70000), strs)
+
+	tests := []struct {
+		name     string
+		codepage uint32
+		strings  []string
+		refSize  int
+	}{
+		{name: "2 byte references", codepage: 65001, strings: strs, refSize: 2},
+		{name: "3 byte references", codepage: 65001 | 0x80000000, strings: largeStrs, refSize: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, data := msiStringPool(tt.codepage, tt.strings)
+			db, err := openMsiDatabase(writeMsiFile(t, map[string][]byte{
+				"!_StringPool": pool,
+				"!_StringData": data,
+				"!Property":    msiPropertyTable(tt.strings, tt.refSize, properties),
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := db.readProperties()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("readProperties() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMsiVersionToRevision(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "1.2.3", want: "16908291"},
+		{version: "1.2.3.4", want: "16908291"},
+		{version: "2", want: "33554432"},
+		{version: "255.255.65535", want: "4294967295"},
+		{version: "256.0.0", wantErr: true},
+		{version: "1.x.0", wantErr: true},
+		{version: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := msiVersionToRevision(tt.version)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("msiVersionToRevision() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package packager
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// Default pin file, used if it exists
+const DefaultPinsFilename = "pins.json"
+
+// Directory in the apk directory where newer revisions of pinned plugins are kept
+const heldDirname = "held"
+
+// Pinned revisions by package name. Newer revisions of pinned packages are held back until the pin is removed.
+type Pins map[string]string
+
+// Load pins from file. If the file does not exist, there are no pins.
+func loadPins(filePath string) (Pins, error) {
+	pins := Pins{}
+	if filePath == "" {
+		return pins, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading pin file: %w", err)
+	}
+
+	err = json.Unmarshal(data, &pins)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pin file %s: %w", filePath, err)
+	}
+	return pins, nil
+}
+
+// Write pins to file
+func savePins(filePath string, pins Pins) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding pins: %w", err)
+	}
+
+	tempPath := filePath + ".tmp"
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing pin file: %w", err)
+	}
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		return fmt.Errorf("error writing pin file: %w", err)
+	}
+	return nil
+}
+
+// Pin the package to the revision. The revision must be published, held back or in the plugins directory.
+func PinPlugin(packageName, revision string, opts PluginsOptions) error {
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	found, err := hasPluginRevision(packageName, revision, opts)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("revision %s of %s is not published, held back or in the plugins directory", revision, packageName)
+	}
+
+	pinsFile := cmp.Or(opts.PinsFile, DefaultPinsFilename)
+	pins, err := loadPins(pinsFile)
+	if err != nil {
+		return err
+	}
+
+	pins[packageName] = revision
+	err = savePins(pinsFile, pins)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Pinned", packageName, "to revision", revision+", newer revisions are held back when plugins are packaged")
+	return nil
+}
+
+// Remove the pin of the package
+func UnpinPlugin(packageName string, opts PluginsOptions) error {
+	unlock, err := lockDirectory(".")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pinsFile := cmp.Or(opts.PinsFile, DefaultPinsFilename)
+	pins, err := loadPins(pinsFile)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := pins[packageName]; !ok {
+		return fmt.Errorf("package %s is not pinned", packageName)
+	}
+	delete(pins, packageName)
+	err = savePins(pinsFile, pins)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Unpinned", packageName+", held revisions are released when plugins are packaged")
+	return nil
+}
+
+// Check if the package revision is in product.infz of the output directory, in the apk directory or held back
+func hasPluginRevision(packageName, revision string, opts PluginsOptions) (bool, error) {
+	isRevision := func(apkInfo ApkInfo) bool {
+		return apkInfo.Package == packageName && compareRevisions(apkInfo.Revision, revision) == 0
+	}
+
+	published, err := readProductInfz(filepath.Join(cmp.Or(opts.OutDir, "."), proructInfzFilename))
+	if err != nil {
+		return false, err
+	}
+	if slices.ContainsFunc(published, isRevision) {
+		return true, nil
+	}
+
+	// Bundles are extracted to a temporary directory, pinning does not change the plugins directory
+	extractDir, removeExtractDir, err := createExtractDir()
+	if err != nil {
+		return false, err
+	}
+	defer removeExtractDir()
+
+	// Files that can not be read are not the revision
+	opts.KeepGoing = true
+	apkInfos, _, err := readApkInfos(opts, extractDir)
+	if err != nil {
+		return false, err
+	}
+	heldInfos, _, err := readHeldApkInfos(opts, extractDir)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(slices.Concat(apkInfos, heldInfos), isRevision), nil
+}
+
+// Held directory of the apk directory
+func heldDir(apkDir string) string {
+	return path.Join(filepath.ToSlash(cmp.Or(apkDir, ".")), heldDirname)
+}
+
+// Read packages held back in the held directory. Returns nothing if the directory does not exist.
+func readHeldApkInfos(opts PluginsOptions, extractDir string) ([]ApkInfo, []readFailure, error) {
+	dir := heldDir(opts.ApkDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	opts.ApkDir = dir
+	opts.Recursive = false
+	apkInfos, failures, err := readApkInfos(opts, extractDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return apkInfos, failures, nil
+}
+
+// File move of a held or released package
+type pinMove struct {
+	apkInfo ApkInfo
+	from    string
+	to      string
+}
+
+// Plan moving newer revisions of pinned packages to the held directory and releasing
+// held packages that are no longer held back. Returns the packages to publish and the moves. If files are not
+// moved, released packages are read from the held directory.
+func planPins(apkInfos, heldInfos []ApkInfo, pins Pins, apkDir string, moveFiles bool) ([]ApkInfo, []pinMove, error) {
+	published := []ApkInfo{}
+	moves := []pinMove{}
+	// Paths planned in this run, so that two moves do not get the same path
+	planned := []ApkInfo{}
+
+	for i, apkInfo := range slices.Concat(apkInfos, heldInfos) {
+		held := i >= len(apkInfos)
+		pinnedRevision, pinned := pins[apkInfo.Package]
+		hold := pinned && compareRevisions(apkInfo.Revision, pinnedRevision) > 0
+
+		if hold == held {
+			if !hold {
+				published = append(published, apkInfo)
+			}
+			continue
+		}
+
+		if hold {
+			fmt.Println("Holding back", apkInfo.DisplayName, "revision", apkInfo.Revision+", pinned to revision", pinnedRevision)
+			if moveFiles {
+				heldPath, err := freePluginFilePath(path.Join(heldDir(apkDir), path.Base(apkInfo.ApkPath)), planned, nil, ".")
+				if err != nil {
+					return nil, nil, err
+				}
+				moves = append(moves, pinMove{apkInfo: apkInfo, from: apkInfo.ApkPath, to: heldPath})
+				planned = append(planned, ApkInfo{ApkPath: heldPath})
+			}
+			continue
+		}
+
+		fmt.Println("Releasing held", apkInfo.DisplayName, "revision", apkInfo.Revision)
+		releasedPath, err := freePluginFilePath(path.Join(filepath.ToSlash(apkDir), path.Base(apkInfo.ApkPath)), planned, nil, ".")
+		if err != nil {
+			return nil, nil, err
+		}
+		if moveFiles {
+			moves = append(moves, pinMove{apkInfo: apkInfo, from: apkInfo.ApkPath, to: releasedPath})
+		} else {
+			apkInfo.SourcePath = cmp.Or(apkInfo.SourcePath, apkInfo.ApkPath)
+		}
+		planned = append(planned, ApkInfo{ApkPath: releasedPath})
+		apkInfo.ApkPath = releasedPath
+		published = append(published, apkInfo)
+	}
+
+	// The pinned revision should stay published
+	for packageName, pinnedRevision := range pins {
+		found := slices.ContainsFunc(published, func(apkInfo ApkInfo) bool {
+			return apkInfo.Package == packageName && compareRevisions(apkInfo.Revision, pinnedRevision) == 0
+		})
+		if !found {
+			fmt.Println("Warning: pinned revision", pinnedRevision, "of", packageName, "was not found")
+		}
+	}
+
+	return published, moves, nil
+}
+
+// Move held and released package files in the transaction
+func movePinnedFiles(tx *transaction, moves []pinMove) error {
+	for _, move := range moves {
+		err := os.MkdirAll(filepath.Dir(move.to), 0755)
+		if err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+		err = tx.rename(move.from, move.to)
+		if err != nil {
+			return err
+		}
+		tx.logChange(newRenameEntry(move.apkInfo, move.to))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package packager
+
+import (
+	"path"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPlanPins(t *testing.T) {
+	held := path.Join(heldDirname, "a.apk")
+	tests := []struct {
+		name      string
+		apkInfos  []ApkInfo
+		heldInfos []ApkInfo
+		pins      Pins
+		moveFiles bool
+		// Files of the published packages
+		want      []string
+		wantMoves []pinMove
+	}{
+		{
+			name:      "unpinned",
+			apkInfos:  []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: "a.apk"}},
+			pins:      Pins{"com.b": "1"},
+			moveFiles: true,
+			want:      []string{"a.apk"},
+		},
+		{
+			name:      "pinned to an older revision",
+			apkInfos:  []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: "a.apk"}},
+			pins:      Pins{"com.a": "1"},
+			moveFiles: true,
+			want:      []string{},
+			wantMoves: []pinMove{{apkInfo: ApkInfo{Package: "com.a", Revision: "2", ApkPath: "a.apk"}, from: "a.apk", to: held}},
+		},
+		{
+			name:      "pinned to the published revision",
+			apkInfos:  []ApkInfo{{Package: "com.a", Revision: "1", ApkPath: "a.apk"}},
+			heldInfos: []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: held}},
+			pins:      Pins{"com.a": "1"},
+			moveFiles: true,
+			want:      []string{"a.apk"},
+		},
+		{
+			name:      "pinned to a newer revision",
+			apkInfos:  []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: "a.apk"}},
+			pins:      Pins{"com.a": "3"},
+			moveFiles: true,
+			want:      []string{"a.apk"},
+		},
+		{
+			name:      "held revision is released when the pin is missing",
+			heldInfos: []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: held}},
+			pins:      Pins{},
+			moveFiles: true,
+			want:      []string{"a.apk"},
+			wantMoves: []pinMove{{apkInfo: ApkInfo{Package: "com.a", Revision: "2", ApkPath: held}, from: held, to: "a.apk"}},
+		},
+		{
+			name:      "held revision is released when pinned to it",
+			heldInfos: []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: held}},
+			pins:      Pins{"com.a": "2"},
+			moveFiles: true,
+			want:      []string{"a.apk"},
+			wantMoves: []pinMove{{apkInfo: ApkInfo{Package: "com.a", Revision: "2", ApkPath: held}, from: held, to: "a.apk"}},
+		},
+		{
+			name:      "released revision is read from the held directory without moving files",
+			heldInfos: []ApkInfo{{Package: "com.a", Revision: "2", ApkPath: held}},
+			pins:      Pins{},
+			want:      []string{"a.apk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			published, moves, err := planPins(tt.apkInfos, tt.heldInfos, tt.pins, "", tt.moveFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, apkInfo := range published {
+				got = append(got, apkInfo.ApkPath)
+				if !tt.moveFiles && apkInfo.SourcePath != held {
+					t.Errorf("source of released %s = %q, want %q", apkInfo.ApkPath, apkInfo.SourcePath, held)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("published = %v, want %v", got, tt.want)
+			}
+			if len(moves) != 0 || len(tt.wantMoves) != 0 {
+				if !reflect.DeepEqual(moves, tt.wantMoves) {
+					t.Errorf("moves = %+v, want %+v", moves, tt.wantMoves)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Parse XML or binary property list. Dictionaries are returned as map[string]any, arrays as []any,
+// and values as string, int64, float64, bool or []byte. Dates are returned as strings.
+func parsePlist(data []byte) (any, error) {
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		return parseBinaryPlist(data)
+	}
+	return parseXMLPlist(data)
+}
+
+// Get string value from plist dictionary
+func plistString(dict map[string]any, key string) string {
+	value, _ := dict[key].(string)
+	return value
+}
+
+// Parse XML property list
+func parseXMLPlist(data []byte) (any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Info.plist files are UTF-8, but may declare other encodings that are compatible
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error reading plist: %w", err)
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local != "plist" {
+			return parseXMLPlistValue(decoder, se)
+		}
+	}
+}
+
+// Parse a single XML plist value starting from its start element
+func parseXMLPlistValue(decoder *xml.Decoder, se xml.StartElement) (any, error) {
+	switch se.Name.Local {
+	case "dict":
+		dict := map[string]any{}
+		key := ""
+		for {
+			t, err := decoder.Token()
+			if err != nil {
+				return nil, fmt.Errorf("error reading plist dict: %w", err)
+			}
+			switch child := t.(type) {
+			case xml.StartElement:
+				if child.Name.Local == "key" {
+					if err := decoder.DecodeElement(&key, &child); err != nil {
+						return nil, fmt.Errorf("error reading plist key: %w", err)
+					}
+					continue
+				}
+				value, err := parseXMLPlistValue(decoder, child)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		array := []any{}
+		for {
+			t, err := decoder.Token()
+			if err != nil {
+				return nil, fmt.Errorf("error reading plist array: %w", err)
+			}
+			switch child := t.(type) {
+			case xml.StartElement:
+				value, err := parseXMLPlistValue(decoder, child)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, fmt.Errorf("error reading plist bool: %w", err)
+		}
+		return se.Name.Local == "true", nil
+	}
+
+	// Rest of the values are text elements
+	var text string
+	if err := decoder.DecodeElement(&text, &se); err != nil {
+		return nil, fmt.Errorf("error reading plist %s: %w", se.Name.Local, err)
+	}
+	text = strings.TrimSpace(text)
+
+	switch se.Name.Local {
+	case "string", "date":
+		return text, nil
+	case "integer":
+		return strconv.ParseInt(text, 10, 64)
+	case "real":
+		return strconv.ParseFloat(text, 64)
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+
+	return nil, fmt.Errorf("unknown plist element %s", se.Name.Local)
+}
+
+// Binary property list reader, see CFBinaryPList.c
+type binaryPlist struct {
+	data          []byte
+	offsets       []uint64
+	objectRefSize int
+	// Objects being read, used to detect reference loops
+	reading map[uint64]bool
+}
+
+// Parse binary property list
+func parseBinaryPlist(data []byte) (any, error) {
+	if len(data) < 8+32 {
+		return nil, errors.New("binary plist too short")
+	}
+
+	trailer := data[len(data)-32:]
+	offsetIntSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if offsetIntSize < 1 || offsetIntSize > 8 || objectRefSize < 1 || objectRefSize > 8 {
+		return nil, errors.New("invalid binary plist trailer")
+	}
+	if numObjects > uint64(len(data)) || offsetTableOffset+numObjects*uint64(offsetIntSize) > uint64(len(data)-32) {
+		return nil, errors.New("invalid binary plist offset table")
+	}
+
+	p := binaryPlist{
+		data:          data,
+		offsets:       make([]uint64, numObjects),
+		objectRefSize: objectRefSize,
+		reading:       map[uint64]bool{},
+	}
+	for i := range p.offsets {
+		start := offsetTableOffset + uint64(i*offsetIntSize)
+		p.offsets[i] = readBigEndian(data[start : start+uint64(offsetIntSize)])
+	}
+
+	return p.object(topObject)
+}
+
+// Read unsigned big endian integer of any size up to 8 bytes
+func readBigEndian(b []byte) uint64 {
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
+
+// Read object by its index in the offset table
+func (p *binaryPlist) object(ref uint64) (any, error) {
+	if ref >= uint64(len(p.offsets)) {
+		return nil, fmt.Errorf("invalid binary plist object reference %d", ref)
+	}
+	if p.reading[ref] {
+		return nil, errors.New("binary plist contains a reference loop")
+	}
+	p.reading[ref] = true
+	defer delete(p.reading, ref)
+
+	offset := p.offsets[ref]
+	if offset >= uint64(len(p.data)) {
+		return nil, fmt.Errorf("invalid binary plist object offset %d", offset)
+	}
+
+	marker := p.data[offset]
+	kind, info := marker>>4, int(marker&0x0f)
+	pos := offset + 1
+
+	switch kind {
+	case 0x0:
+		switch info {
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+		return nil, nil
+	case 0x1:
+		size := uint64(1) << info
+		b, err := p.bytes(pos, size)
+		if err != nil {
+			return nil, err
+		}
+		return int64(readBigEndian(b)), nil
+	case 0x2:
+		size := uint64(1) << info
+		b, err := p.bytes(pos, size)
+		if err != nil {
+			return nil, err
+		}
+		if size == 4 {
+			return float64(math.Float32frombits(uint32(readBigEndian(b)))), nil
+		}
+		return math.Float64frombits(readBigEndian(b)), nil
+	case 0x3:
+		// Dates are seconds since 2001-01-01, returned as is
+		b, err := p.bytes(pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatFloat(math.Float64frombits(readBigEndian(b)), 'f', -1, 64), nil
+	}
+
+	// Rest of the objects have a length
+	length, pos, err := p.length(info, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 0x4:
+		return p.bytes(pos, length)
+	case 0x5:
+		b, err := p.bytes(pos, length)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 0x6:
+		b, err := p.bytes(pos, length*2)
+		if err != nil {
+			return nil, err
+		}
+		chars := make([]uint16, length)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(chars)), nil
+	case 0xA:
+		refs, err := p.refs(pos, length)
+		if err != nil {
+			return nil, err
+		}
+		array := []any{}
+		for _, r := range refs {
+			value, err := p.object(r)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case 0xD:
+		refs, err := p.refs(pos, length*2)
+		if err != nil {
+			return nil, err
+		}
+		dict := map[string]any{}
+		for i := uint64(0); i < length; i++ {
+			key, err := p.object(refs[i])
+			if err != nil {
+				return nil, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, errors.New("binary plist dictionary key is not a string")
+			}
+			value, err := p.object(refs[length+i])
+			if err != nil {
+				return nil, err
+			}
+			dict[keyString] = value
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("unknown binary plist object type 0x%x", marker)
+}
+
+// Read length of an object, which is either in the marker or in the following integer object
+func (p *binaryPlist) length(info int, pos uint64) (uint64, uint64, error) {
+	if info != 0xf {
+		return uint64(info), pos, nil
+	}
+	b, err := p.bytes(pos, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b[0]>>4 != 0x1 {
+		return 0, 0, errors.New("invalid binary plist object length")
+	}
+	size := uint64(1) << (b[0] & 0x0f)
+	b, err = p.bytes(pos+1, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readBigEndian(b), pos + 1 + size, nil
+}
+
+// Read object references
+func (p *binaryPlist) refs(pos, count uint64) ([]uint64, error) {
+	b, err := p.bytes(pos, count*uint64(p.objectRefSize))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, count)
+	for i := range refs {
+		refs[i] = readBigEndian(b[i*p.objectRefSize : (i+1)*p.objectRefSize])
+	}
+	return refs, nil
+}
+
+// Get bytes from the plist checking the bounds
+func (p *binaryPlist) bytes(pos, size uint64) ([]byte, error) {
+	if size > uint64(len(p.data)) || pos > uint64(len(p.data))-size {
+		return nil, errors.New("binary plist object out of bounds")
+	}
+	return p.data[pos : pos+size], nil
+}
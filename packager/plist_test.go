@@ -0,0 +1,259 @@
+package packager
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseXMLPlist(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want any
+	}{
+		{
+			name: "dict",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.example.hello</string>
+	<key>CFBundleIcons</key>
+	<dict>
+		<key>CFBundlePrimaryIcon</key>
+		<dict>
+			<key>CFBundleIconFiles</key>
+			<array>
+				<string>AppIcon60x60</string>
+			</array>
+		</dict>
+	</dict>
+	<key>LSRequiresIPhoneOS</key>
+	<true/>
+	<key>UIPrerenderedIcon</key>
+	<false/>
+	<key>Count</key>
+	<integer>-42</integer>
+	<key>Scale</key>
+	<real>1.5</real>
+	<key>Data</key>
+	<data>
+	aGVs
+	bG8=
+	</data>
+	<key>Date</key>
+	<date>2024-01-02T03:04:05Z</date>
+</dict>
+</plist>`,
+			want: map[string]any{
+				"CFBundleIdentifier": "com.example.hello",
+				"CFBundleIcons": map[string]any{
+					"CFBundlePrimaryIcon": map[string]any{
+						"CFBundleIconFiles": []any{"AppIcon60x60"},
+					},
+				},
+				"LSRequiresIPhoneOS": true,
+				"UIPrerenderedIcon":  false,
+				"Count":              int64(-42),
+				"Scale":              1.5,
+				"Data":               []byte("hello"),
+				"Date":               "2024-01-02T03:04:05Z",
+			},
+		},
+		{
+			name: "other encoding",
+			data: `<?xml version="1.0" encoding="ISO-8859-1"?><plist><array><string>a</string><string/></array></plist>`,
+			want: []any{"a", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlist([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePlist() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXMLPlistErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown element", data: `<plist><dict><key>a</key><uid>1</uid></dict></plist>`},
+		{name: "invalid integer", data: `<plist><integer>one</integer></plist>`},
+		{name: "invalid data", data: `<plist><data>!!</data></plist>`},
+		{name: "unclosed", data: `<plist><dict><key>a</key>`},
+		{name: "empty", data: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePlist([]byte(tt.data))
+			if err == nil {
+				t.Error("parsePlist() succeeded, want error")
+			}
+		})
+	}
+}
+
+// Build binary plist of the encoded objects with 1 byte object references. The first object is the top object.
+func buildBinaryPlist(objects ...[]byte) []byte {
+	data := []byte("bplist00")
+	offsets := []byte{}
+	for _, object := range objects {
+		offsets = binary.BigEndian.AppendUint16(offsets, uint16(len(data)))
+		data = append(data, object...)
+	}
+	offsetTableOffset := len(data)
+	data = append(data, offsets...)
+
+	trailer := make([]byte, 32)
+	trailer[6] = 2
+	trailer[7] = 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(offsetTableOffset))
+	return append(data, trailer...)
+}
+
+// Binary plist object with the marker and the content
+func plistObject(marker byte, content ...byte) []byte {
+	return append([]byte{marker}, content...)
+}
+
+func TestParseBinaryPlist(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	utf16Name := []byte{0, 'h', 0, 0xe9, 0xd8, 0x3d, 0xde, 0x00}
+	real64 := binary.BigEndian.AppendUint64(nil, math.Float64bits(2.5))
+	real32 := binary.BigEndian.AppendUint32(nil, math.Float32bits(0.5))
+
+	tests := []struct {
+		name    string
+		objects [][]byte
+		want    any
+	}{
+		{
+			name: "dict",
+			objects: [][]byte{
+				plistObject(0xD2, 1, 2, 3, 4),
+				plistObject(0x52, 'i', 'd'),
+				plistObject(0x51, 'n'),
+				plistObject(0x55, 'h', 'e', 'l', 'l', 'o'),
+				plistObject(0x11, 0x01, 0x00),
+			},
+			want: map[string]any{"id": "hello", "n": int64(256)},
+		},
+		{
+			name: "array",
+			objects: [][]byte{
+				plistObject(0xA8, 1, 2, 3, 4, 5, 6, 7, 8),
+				plistObject(0x09),
+				plistObject(0x08),
+				plistObject(0x10, 7),
+				plistObject(0x23, real64...),
+				plistObject(0x22, real32...),
+				plistObject(0x43, 1, 2, 3),
+				plistObject(0x64, utf16Name...),
+				plistObject(0x33, 0, 0, 0, 0, 0, 0, 0, 0),
+			},
+			want: []any{true, false, int64(7), 2.5, 0.5, []byte{1, 2, 3}, "hé😀", "0"},
+		},
+		{
+			name: "long string",
+			objects: [][]byte{
+				append(plistObject(0x5F, 0x10, byte(len(long))), long...),
+			},
+			want: long,
+		},
+		{
+			name: "shared object",
+			objects: [][]byte{
+				plistObject(0xA2, 1, 1),
+				plistObject(0x51, 'a'),
+			},
+			want: []any{"a", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlist(buildBinaryPlist(tt.objects...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePlist() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBinaryPlistErrors(t *testing.T) {
+	valid := buildBinaryPlist(plistObject(0x51, 'a'))
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "too short",
+			data: valid[:20],
+			want: "binary plist too short",
+		},
+		{
+			name: "trailer",
+			data: slices.Concat(valid[:len(valid)-32+7], []byte{0}, valid[len(valid)-32+8:]),
+			want: "invalid binary plist trailer",
+		},
+		{
+			name: "offset table",
+			data: slices.Concat(valid[:len(valid)-8], []byte{0, 0, 0, 0, 0, 0, 1, 0}),
+			want: "invalid binary plist offset table",
+		},
+		{
+			name: "reference loop",
+			data: buildBinaryPlist(plistObject(0xA1, 1), plistObject(0xA1, 0)),
+			want: "binary plist contains a reference loop",
+		},
+		{
+			name: "object reference",
+			data: buildBinaryPlist(plistObject(0xA1, 5)),
+			want: "invalid binary plist object reference 5",
+		},
+		{
+			name: "object out of bounds",
+			data: buildBinaryPlist(plistObject(0x5F, 0x10, 200, 'a')),
+			want: "binary plist object out of bounds",
+		},
+		{
+			name: "object length",
+			data: buildBinaryPlist(plistObject(0x5F, 0x50, 'a')),
+			want: "invalid binary plist object length",
+		},
+		{
+			name: "dictionary key",
+			data: buildBinaryPlist(plistObject(0xD1, 1, 1), plistObject(0x10, 1)),
+			want: "binary plist dictionary key is not a string",
+		},
+		{
+			name: "object type",
+			data: buildBinaryPlist(plistObject(0x80)),
+			want: "unknown binary plist object type 0x80",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePlist(tt.data)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("parsePlist() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,877 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+	"github.com/pvarki/golang-tak-taktool/manifest"
+)
+
+// Information of a single package, one row in product.inf.
+// Packages other than apks are read into the same structure.
+type ApkInfo = artifact.Info
+
+// Options for creating the plugins package
+type PluginsOptions struct {
+	RenamePlugins bool
+	PolicyFile    string
+	// Only package plugins compatible with one of these ABIs, empty packages all
+	Abis []string
+	// Print listings as JSON
+	JSON bool
+	// Directory of the package files, empty is the current directory
+	ApkDir string
+	// Directory of the icons in product.infz, empty puts icons next to the package files
+	IconDir string
+	// Read package files from subdirectories of the apk directory too
+	Recursive bool
+	// Skip files that can not be read instead of failing
+	KeepGoing bool
+	// Pin file, used if it exists
+	PinsFile string
+	// Number of files read at the same time, 0 is the number of CPUs
+	Jobs int
+	// Parse all files instead of using the metadata cache
+	NoCache bool
+	// Number of newest snapshots kept when pruning history
+	KeepSnapshots int
+	// Operator name in the audit log, empty uses TAKTOOL_OPERATOR or the current user
+	Operator string
+	// Directory where product.infz and SBOM are written, empty is the current directory. If it is
+	// another directory, packages are copied there and the current directory is not changed.
+	OutDir string
+}
+
+const proructInfzFilename = "product.infz"
+const productInfFilename = "product.inf"
+
+func PackagePlugins(opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	copyToOutDir, err := isOtherDirectory(outDir)
+	if err != nil {
+		return fmt.Errorf("error checking output directory: %w", err)
+	}
+
+	// Packaging would race with another taktool changing the same directory
+	unlock, err := lockDirectory(outDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Finish or roll back the changes of interrupted packaging before reading the directory
+	err = recoverJournal(outDir)
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	// All file changes are rolled back if packaging fails
+	var failures []readFailure
+	err = inTransaction(outDir, func(tx *transaction) error {
+		failures, err = packageDirectory(tx, outDir, copyToOutDir, nil, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return printPackaged(outDir, failures)
+}
+
+// Read the plugins of the input directory and package them with the copied plugins in the transaction. Copied plugins
+// are written from their source paths when packaging in place. Returns the files that could not be read.
+func packageDirectory(tx *transaction, outDir string, copyToOutDir bool, copied []ApkInfo, opts PluginsOptions) ([]readFailure, error) {
+	policy, err := loadPolicy(opts.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading policy: %w", err)
+	}
+
+	pins, err := loadPins(opts.PinsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bundles are extracted to a temporary directory, and copied next to the bundles in the transaction
+	// if the input directory is changed
+	extractDir, removeExtractDir, err := createExtractDir()
+	if err != nil {
+		return nil, err
+	}
+	defer removeExtractDir()
+
+	apkInfos, failures, err := readApkInfos(opts, extractDir)
+	if err != nil {
+		return nil, err
+	}
+	apkInfos = append(apkInfos, copied...)
+
+	// Newer revisions of pinned plugins are held back, and held plugins that are no longer pinned are released
+	heldInfos, heldFailures, err := readHeldApkInfos(opts, extractDir)
+	if err != nil {
+		return nil, err
+	}
+	failures = append(failures, heldFailures...)
+	extracted := []ApkInfo{}
+	if !copyToOutDir {
+		extracted = extractedApkInfos(slices.Concat(apkInfos, heldInfos))
+	}
+	apkInfos, pinMoves, err := planPins(apkInfos, heldInfos, pins, opts.ApkDir, !copyToOutDir)
+	if err != nil {
+		return nil, fmt.Errorf("error applying pins: %w", err)
+	}
+
+	// Leave out plugins that do not support the requested device architectures
+	if len(opts.Abis) > 0 {
+		apkInfos = filterApkInfosByAbi(apkInfos, opts.Abis)
+	}
+
+	// Do not package anything if some of the apks are not allowed by the policy
+	err = policy.Enforce(apkInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeExtractedApks(tx, extracted)
+	if err != nil {
+		return nil, fmt.Errorf("error writing apks extracted from bundles: %w", err)
+	}
+	return failures, packagePlugins(tx, apkInfos, pinMoves, failures, outDir, copyToOutDir, opts)
+}
+
+// Print the written files, and return ErrPackagesSkipped if some files could not be read
+func printPackaged(outDir string, failures []readFailure) error {
+	fmt.Println("Package created:", filepath.Join(outDir, proructInfzFilename))
+	fmt.Println("SBOM created:", filepath.Join(outDir, sbomFilename))
+
+	if len(failures) > 0 {
+		fmt.Println("Failure report created:", filepath.Join(outDir, failureReportFilename))
+		return fmt.Errorf("%w: %d files", ErrPackagesSkipped, len(failures))
+	}
+
+	return nil
+}
+
+// Hold back and release pinned plugins, rename and remove plugins, or copy them to the output directory,
+// and write product.infz and SBOM in the transaction
+func packagePlugins(tx *transaction, apkInfos []ApkInfo, pinMoves []pinMove, failures []readFailure, outDir string, copyToOutDir bool, opts PluginsOptions) error {
+	err := movePinnedFiles(tx, pinMoves)
+	if err != nil {
+		return fmt.Errorf("error moving held plugins: %w", err)
+	}
+
+	if copyToOutDir {
+		apkInfos, err = copyPlugins(tx, apkInfos, outDir, opts.RenamePlugins)
+		if err != nil {
+			return fmt.Errorf("error copying plugins: %w", err)
+		}
+	} else if opts.RenamePlugins {
+		// If renamePlugins is true, rework the name of the apk file and remove older revisions of the same package
+		apkInfos, err = RemoveOlderPluginVersions(tx, apkInfos)
+		if err != nil {
+			return fmt.Errorf("error removing older versions: %w", err)
+		}
+		apkInfos, err = RenamePlugins(tx, apkInfos)
+		if err != nil {
+			return fmt.Errorf("error renaming plugins: %w", err)
+		}
+	}
+
+	// Check if there are custom images in the images directory
+	customImagesList, err := checkForCustomImages()
+	if err != nil {
+		return fmt.Errorf("error checking for custom images: %w", err)
+	}
+
+	// Create product.infz zip
+	var productInfz bytes.Buffer
+	err = writeProductInfz(&productInfz, apkInfos, opts.IconDir, customImagesList)
+	if err != nil {
+		return err
+	}
+
+	// Create SBOM describing the packaged apks
+	var sbom bytes.Buffer
+	err = writeSbom(&sbom, apkInfos)
+	if err != nil {
+		return fmt.Errorf("error writing SBOM: %w", err)
+	}
+
+	err = writeIndexFiles(tx, apkInfos, outDir, productInfz.Bytes(), sbom.Bytes(), opts)
+	if err != nil {
+		return err
+	}
+
+	// Write the files that could not be read, or remove the report of earlier packaging
+	reportPath := filepath.Join(outDir, failureReportFilename)
+	if len(failures) > 0 {
+		err = tx.writeFile(reportPath, func(w io.Writer) error {
+			_, err := io.WriteString(w, createFailureReport(failures))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error writing failure report: %w", err)
+		}
+	} else if _, err := os.Stat(reportPath); err == nil {
+		err = tx.remove(reportPath)
+		if err != nil {
+			return fmt.Errorf("error removing failure report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Copy plugins to the output directory. If rename is true, plugins get their preferred names and older versions
+// are left out.
+func copyPlugins(tx *transaction, apkInfos []ApkInfo, outDir string, rename bool) ([]ApkInfo, error) {
+	var err error
+	newPaths := []string{}
+
+	if rename {
+		apkInfos, err = RemoveOlderPluginVersions(nil, apkInfos)
+		if err != nil {
+			return apkInfos, err
+		}
+		newPaths, err = pluginFilePaths(apkInfos, false)
+		if err != nil {
+			return apkInfos, err
+		}
+	} else {
+		for _, apkData := range apkInfos {
+			newPaths = append(newPaths, apkData.ApkPath)
+		}
+	}
+
+	for i, apkData := range apkInfos {
+		sourcePath := cmp.Or(apkData.SourcePath, apkData.ApkPath)
+		err := tx.writeFile(filepath.Join(outDir, newPaths[i]), copyFile(sourcePath))
+		if err != nil {
+			return apkInfos, err
+		}
+
+		apkInfos[i].SourcePath = sourcePath
+		apkInfos[i].ApkPath = newPaths[i]
+	}
+
+	return apkInfos, nil
+}
+
+// Check if the directory is other than the current directory
+func isOtherDirectory(dir string) (bool, error) {
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return false, err
+	}
+	currentDirInfo, err := os.Stat(".")
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(dirInfo, currentDirInfo), nil
+}
+
+// Write product.infz with icons of the packages and product.inf
+func writeProductInfz(w io.Writer, apkInfos []ApkInfo, iconDir string, customImagesList []string) error {
+	zipWriter := zip.NewWriter(w)
+	usedImageFileNames := map[string]bool{}
+
+	// Get icon files from apk files and add them to zip
+	for i, apkInfo := range apkInfos {
+		newImageFileName := iconFileName(apkInfo.ApkPath, iconDir, usedImageFileNames)
+		usedImageFileNames[newImageFileName] = true
+		apkInfos[i].IconPath = newImageFileName
+
+		// Custom images are named after the package file, e.g. "atak_app.apk" -> "atak_app.png"
+		customImageName := strings.TrimSuffix(path.Base(apkInfo.ApkPath), path.Ext(apkInfo.ApkPath)) + ".png"
+		customImageFound := slices.Contains(customImagesList, customImageName)
+
+		fw, err := zipWriter.Create(newImageFileName)
+		if err != nil {
+			return fmt.Errorf("error creating icon file into zip: %w", err)
+		}
+
+		// If a custom image is found, use it instead of the image from the apk package
+		if customImageFound {
+			// Copy custom image to zip with the name newImageFileName
+			customImagePath := filepath.Join("images", customImageName)
+			customImageFile, err := os.Open(customImagePath)
+			if err != nil {
+				return fmt.Errorf("error opening custom image file: %w", err)
+			}
+			defer customImageFile.Close()
+
+			// Copy custom image file to zip
+			_, err = io.Copy(fw, customImageFile)
+			if err != nil {
+				return fmt.Errorf("error copying custom image file: %w", err)
+			}
+			fmt.Println("Using custom image for package", apkInfo.DisplayName, ":", customImageName)
+			continue
+		}
+
+		// Use icon read with the package, or read it with the reader of the package type
+		iconData := apkInfo.IconData
+		if reader := artifact.Lookup(apkInfo.Format); iconData == nil && reader != nil {
+			iconData, err = reader.Icon(cmp.Or(apkInfo.SourcePath, apkInfo.ApkPath), apkInfo)
+			if err != nil {
+				return fmt.Errorf("error reading icon of %s: %w", apkInfo.DisplayName, err)
+			}
+		}
+
+		if iconData == nil {
+			fmt.Println("Package", apkInfo.DisplayName, "does not have a png icon file. Creating empty png file...")
+
+			// Empty png file
+			iconData = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A, 0x00, 0x00, 0x00, 0x0D, 0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x00, 0x00, 0x1F, 0x15, 0xC4, 0x89, 0x00, 0x00, 0x00, 0x0A, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9C, 0x63, 0x00, 0x01, 0x00, 0x00, 0x05, 0x00, 0x01, 0x0D, 0x0A, 0x2D, 0xB4, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4E, 0x44, 0xAE, 0x42, 0x60, 0x82}
+		}
+
+		// Add icon to zip
+		_, err = fw.Write(iconData)
+		if err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+	}
+
+	// Write product.inf
+	mf, err := zipWriter.Create(productInfFilename)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	_, err = mf.Write([]byte(createProductInf(apkInfos)))
+	if err != nil {
+		return fmt.Errorf("error writing product.inf: %w", err)
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", proructInfzFilename, err)
+	}
+
+	return nil
+}
+
+// Get the path of the icon of the package file in product.infz. Icons are next to the package files
+// unless icon directory is set. A number is added to the name if the path is already used.
+func iconFileName(apkPath, iconDir string, used map[string]bool) string {
+	name := strings.TrimSuffix(path.Base(apkPath), path.Ext(apkPath))
+	dir := path.Dir(apkPath)
+	if iconDir != "" {
+		dir = filepath.ToSlash(iconDir)
+	}
+
+	iconPath := path.Join(dir, name+".png")
+	for n := 2; used[iconPath]; n++ {
+		iconPath = path.Join(dir, fmt.Sprintf("%s_%d.png", name, n))
+	}
+	return iconPath
+}
+
+// Read apk data from every package file in the apk directory, and its subdirectories if recursive is set.
+// Installable apks are extracted from app bundles to the extract directory, split apks are skipped.
+// Path of an extracted apk is next to its bundle, and the source path is in the extract directory.
+// With KeepGoing option, files that can not be read are returned as failures instead of an error.
+func readApkInfos(opts PluginsOptions, extractDir string) ([]ApkInfo, []readFailure, error) {
+	apkInfos := []ApkInfo{}
+	bundles := []string{}
+	failures := []readFailure{}
+
+	files, err := listPackageFiles(opts.ApkDir, opts.Recursive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	// Record the failure and continue if keep going, otherwise return the error
+	fail := func(filePath string, err error) error {
+		if !opts.KeepGoing {
+			return fmt.Errorf("error reading %s: %w", filePath, err)
+		}
+		failure := newReadFailure(filePath, err)
+		fmt.Println("Skipping", failure)
+		failures = append(failures, failure)
+		return nil
+	}
+
+	// Bundles are extracted after all apks are known, other files are read in parallel
+	packageFiles := []string{}
+	for _, filePath := range files {
+		if isBundleFile(filePath) {
+			bundles = append(bundles, filePath)
+		} else {
+			packageFiles = append(packageFiles, filePath)
+		}
+	}
+
+	// Only new or changed files are parsed if the metadata cache is used
+	var cache *metadataCache
+	if !opts.NoCache {
+		cache, err = openMetadataCache()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	results := readArtifacts(packageFiles, opts.Jobs, cache)
+	if cache != nil {
+		err = cache.save()
+		if err != nil {
+			fmt.Println("Metadata cache could not be saved:", err)
+		}
+	}
+
+	// Base apks can not be installed without the loose split apks of the same package
+	splits := map[string]int{}
+	for _, result := range results {
+		if result.reader != nil && result.err == nil && result.info.Split != "" {
+			splits[result.info.Package]++
+		}
+	}
+
+	// Loop through the results in file order and get apk data from each package file
+	for i, filePath := range packageFiles {
+		result := results[i]
+		if result.reader == nil {
+			// Not a package file
+			continue
+		}
+
+		apkData, err := result.info, result.err
+		if err != nil {
+			err = fail(filePath, fmt.Errorf("error getting %s data: %w", result.reader.Name(), err))
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		// Split apks can not be installed alone
+		if apkData.Split != "" {
+			fmt.Println("Skipping split apk", filePath, "of package", apkData.Package)
+			continue
+		}
+		if apkData.RequiresSplits {
+			err = fail(filePath, fmt.Errorf("%s requires split apks and cannot be installed via the update server", filePath))
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if splits[apkData.Package] > 0 {
+			err = fail(filePath, fmt.Errorf("%s cannot be installed without its %d split apks, build a universal apk instead (bundletool build-apks --mode=universal)", filePath, splits[apkData.Package]))
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		apkInfos = append(apkInfos, apkData)
+	}
+
+	// Extract installable apks from bundles
+	for _, bundle := range bundles {
+		apkPath, err := extractBundle(bundle, apkInfos, extractDir, opts.Abis)
+		if err != nil {
+			err = fail(bundle, fmt.Errorf("error extracting bundle %s: %w", bundle, err))
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if apkPath == "" {
+			continue
+		}
+
+		apkData, err := getApkData(filepath.Join(extractDir, apkPath))
+		if err != nil {
+			err = fail(bundle, fmt.Errorf("error getting apk data: %w", err))
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		apkData.SourcePath = apkData.ApkPath
+		apkData.ApkPath = cleanupValue(apkPath)
+
+		apkInfos = append(apkInfos, apkData)
+	}
+
+	// Descriptions can be overridden, e.g. with release notes of ingested archives
+	descriptions, err := loadDescriptions(descriptionsFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyDescriptions(apkInfos, descriptions)
+
+	return apkInfos, failures, nil
+}
+
+// Directories of taktool that are not read as part of the plugins directory
+var reservedDirnames = []string{heldDirname, channelsDirname, incomingDirname, rejectedDirname}
+
+// List files in the directory, skipping hidden files and directories. Paths are relative to the current directory
+// with forward slashes, as they are written to product.inf.
+func listPackageFiles(dir string, recursive bool) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+
+	files := []string{}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != dir && (!recursive || strings.HasPrefix(entry.Name(), ".") || slices.Contains(reservedDirnames, entry.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, filepath.ToSlash(filePath))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Check if there are custom images in the images directory
+func checkForCustomImages() ([]string, error) {
+	customImagesList := []string{}
+
+	// Check if the images directory exists
+	if _, err := os.Stat("./images"); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// Check if there are images directory in the current directory
+	dirContents, err := os.ReadDir("./images")
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	// Add all png files in the images directory to the customImagesList
+	for _, entry := range dirContents {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".png") {
+			customImagesList = append(customImagesList, entry.Name())
+		}
+	}
+
+	return customImagesList, nil
+}
+
+// Leave out older revisions of the same package. Files of the older versions are removed in the transaction,
+// or kept if tx is nil.
+func RemoveOlderPluginVersions(tx *transaction, apkInfos []ApkInfo) ([]ApkInfo, error) {
+	// Loop through apkInfos and check if there are several revisions of the same package
+	// If there are, remove the older version based on the revision number
+	for i := 0; i < len(apkInfos); i++ {
+		for j := i + 1; j < len(apkInfos); j++ {
+			// Plugins with the same label are different plugins unless the package is the same
+			if apkInfos[i].Package == apkInfos[j].Package && apkInfos[i].Platform == apkInfos[j].Platform {
+				// Remove the older version based on the revision number
+				if compareRevisions(apkInfos[i].Revision, apkInfos[j].Revision) < 0 {
+					// Remove the older version
+					fmt.Println("Removing older version:", apkInfos[i].DisplayName, "revision:", apkInfos[i].Revision)
+					if tx != nil {
+						err := tx.remove(apkInfos[i].ApkPath)
+						if err != nil {
+							return apkInfos, err
+						}
+						tx.logChange(newAuditEntry(auditRemoved, apkInfos[i]))
+					}
+					apkInfos = append(apkInfos[:i], apkInfos[i+1:]...)
+					i-- // Adjust index after removal
+					break
+				} else {
+					// Remove the older version
+					fmt.Println("Removing older version:", apkInfos[j].DisplayName, "revision:", apkInfos[j].Revision)
+					if tx != nil {
+						err := tx.remove(apkInfos[j].ApkPath)
+						if err != nil {
+							return apkInfos, err
+						}
+						tx.logChange(newAuditEntry(auditRemoved, apkInfos[j]))
+					}
+					apkInfos = append(apkInfos[:j], apkInfos[j+1:]...)
+					j-- // Adjust index after removal
+				}
+			}
+		}
+	}
+
+	return apkInfos, nil
+}
+
+func RenamePlugins(tx *transaction, apkInfos []ApkInfo) ([]ApkInfo, error) {
+	// Rename the apk files to a new name based on DisplayName and Type
+	newPaths, err := pluginFilePaths(apkInfos, true)
+	if err != nil {
+		return apkInfos, err
+	}
+
+	// Move the files to temporary names first, so that a file is never renamed over another file of the batch
+	tempPaths := map[int]string{}
+	for i, apkData := range apkInfos {
+		if newPaths[i] == apkData.ApkPath {
+			continue
+		}
+		tempPath := filepath.Join(filepath.Dir(apkData.ApkPath), fmt.Sprintf(".taktool-rename-%d%s", i, filepath.Ext(apkData.ApkPath)))
+		err := tx.rename(apkData.ApkPath, tempPath)
+		if err != nil {
+			return apkInfos, err
+		}
+		tempPaths[i] = tempPath
+	}
+
+	for i, tempPath := range tempPaths {
+		err := tx.rename(tempPath, newPaths[i])
+		if err != nil {
+			return apkInfos, err
+		}
+		tx.logChange(newRenameEntry(apkInfos[i], newPaths[i]))
+		apkInfos[i].ApkPath = newPaths[i] // Update entry to the new name
+	}
+	return apkInfos, nil
+}
+
+// Choose a unique file path for every package from DisplayName and Type. If several packages get the same name,
+// they are told apart by their package names and then by a number. If checkExisting is true, existing files that
+// are not renamed are never used as a new name.
+func pluginFilePaths(apkInfos []ApkInfo, checkExisting bool) ([]string, error) {
+	newPaths, err := choosePluginFilePaths(apkInfos, checkExisting)
+	if err != nil {
+		return nil, err
+	}
+	for i, apkData := range apkInfos {
+		printPluginNameInUse(apkData, newPaths[i])
+	}
+	return newPaths, nil
+}
+
+// Print a note if the package does not get the file name of its label
+func printPluginNameInUse(apkData ApkInfo, newPath string) {
+	if newPath != pluginFilePath(apkData, reworkPluginName(apkData.DisplayName+"_"+apkData.Type)) {
+		fmt.Println("Plugin name of", apkData.DisplayName, "is already in use, using", newPath)
+	}
+}
+
+// Path of the package file renamed to the name, in the same directory and with the same extension
+func pluginFilePath(apkData ApkInfo, name string) string {
+	return path.Join(path.Dir(apkData.ApkPath), name+strings.ToLower(path.Ext(apkData.ApkPath)))
+}
+
+// Choose the file paths of pluginFilePaths without printing them
+func choosePluginFilePaths(apkInfos []ApkInfo, checkExisting bool) ([]string, error) {
+
+	// Files of the batch are free to be used, as they are all renamed
+	renamed := map[string]bool{}
+	// Count packages wanting the same name
+	wanted := map[string]int{}
+	for _, apkData := range apkInfos {
+		renamed[apkData.ApkPath] = true
+		wanted[pluginFilePath(apkData, reworkPluginName(apkData.DisplayName+"_"+apkData.Type))]++
+	}
+
+	taken := map[string]bool{}
+	isFree := func(filePath string) (bool, error) {
+		if taken[filePath] {
+			return false, nil
+		}
+		if renamed[filePath] || !checkExisting {
+			return true, nil
+		}
+		_, err := os.Stat(filePath)
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	newPaths := make([]string, len(apkInfos))
+	for i, apkData := range apkInfos {
+		name := reworkPluginName(apkData.DisplayName + "_" + apkData.Type)
+		if wanted[pluginFilePath(apkData, name)] > 1 {
+			name = reworkPluginName(apkData.Package + "_" + apkData.Type)
+		}
+
+		newPath := pluginFilePath(apkData, name)
+		for n := 2; ; n++ {
+			free, err := isFree(newPath)
+			if err != nil {
+				return nil, err
+			}
+			if free {
+				break
+			}
+			newPath = pluginFilePath(apkData, fmt.Sprintf("%s_%d", name, n))
+		}
+
+		taken[newPath] = true
+		newPaths[i] = newPath
+	}
+
+	return newPaths, nil
+}
+
+// Read apk data with the apk reader
+func getApkData(apkPath string) (ApkInfo, error) {
+	return readArtifact(apkReader{}, apkPath)
+}
+
+// Fill apk info from the parameters of the manifest
+func apkInfoFromManifest(m *manifest.Manifest) ApkInfo {
+	app := m.Application
+
+	apkData := ApkInfo{
+		Platform:       "Android",
+		OsReq:          1,
+		Package:        cleanupValue(m.Package),
+		Version:        cleanupValue(m.VersionName),
+		Revision:       cleanupValue(m.VersionCode),
+		DisplayName:    cleanupValue(app.Label),
+		Description:    cleanupValue(app.Description),
+		IconPath:       cleanupValue(app.Icon),
+		Debuggable:     app.Debuggable,
+		TestOnly:       app.TestOnly,
+		MinSdk:         m.UsesSdk.MinSdkVersion,
+		TargetSdk:      m.UsesSdk.TargetSdkVersion,
+		Split:          m.Split,
+		RequiresSplits: m.RequiresSplits(),
+	}
+
+	// If the package name ends with .plugin, it is a plugin
+	if strings.HasSuffix(apkData.Package, ".plugin") {
+		apkData.Type = "plugin"
+	} else {
+		apkData.Type = "app"
+	}
+
+	if takReq, ok := app.MetaDataValue("plugin-api"); ok {
+		apkData.TakReq = cleanupValue(takReq)
+	}
+	if appDesc, ok := app.MetaDataValue("app_desc"); ok && apkData.Description == "" {
+		apkData.Description = cleanupValue(appDesc)
+	}
+
+	for _, permission := range m.UsesPermissions {
+		apkData.Permissions = append(apkData.Permissions, permission.Name)
+	}
+
+	for _, feature := range m.UsesFeatures {
+		name := feature.Name
+		if !feature.Required {
+			name += " (optional)"
+		}
+		apkData.Features = append(apkData.Features, name)
+	}
+
+	for _, component := range app.Components() {
+		if component.IsExported() {
+			apkData.ExportedComponents = append(apkData.ExportedComponents, component.Kind+":"+component.Name)
+		}
+	}
+
+	return apkData
+}
+
+// Calculate hash SHA-256 from file
+func calculateHash(filePath string) (string, error) {
+	return artifact.HashFile(filePath)
+}
+
+// Create product.inf file content
+func createProductInf(apkInfos []ApkInfo) string {
+	// First line of product.inf file
+	productInf := "#platform (Android Windows or iOS), type (app or plugin), full package name, display/label, version, revision code (integer), relative path to APK file, relative path to icon file, description, apk hash, os requirement, tak prereq (e.g. plugin-api), apk size"
+
+	// Order apkInfos
+	apkInfos = sortApkInfos(apkInfos)
+
+	// Loop through apkInfos and add them to productInf
+	for _, apkInfo := range apkInfos {
+		productInf += fmt.Sprintf("\n%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%d,%s,%d",
+			apkInfo.Platform,
+			apkInfo.Type,
+			apkInfo.Package,
+			apkInfo.DisplayName,
+			apkInfo.Version,
+			apkInfo.Revision,
+			apkInfo.ApkPath,
+			apkInfo.IconPath,
+			apkInfo.Description,
+			apkInfo.Hash,
+			apkInfo.OsReq,
+			apkInfo.TakReq,
+			apkInfo.Size,
+		)
+	}
+
+	return productInf
+}
+
+// Clean up value by cutting it at line break and removing commas
+func cleanupValue(str string) string {
+	// Cut string at line break
+	str = strings.Split(str, "\n")[0]
+	// Remove commas from string
+	str = strings.ReplaceAll(str, ",", "")
+	return str
+}
+
+// Compare revisions as integers. iOS build numbers may have several dot separated integers.
+func compareRevisions(a, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < max(len(partsA), len(partsB)); i++ {
+		var numberA, numberB int
+		if i < len(partsA) {
+			numberA, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			numberB, _ = strconv.Atoi(partsB[i])
+		}
+		if c := cmp.Compare(numberA, numberB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Order apkInfos by Platform, Type, Package, DisplayName, Version
+func sortApkInfos(apkInfos []ApkInfo) []ApkInfo {
+
+	slices.SortFunc(apkInfos, func(a, b ApkInfo) int {
+		return cmp.Or(
+			cmp.Compare(a.Platform, b.Platform),
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.DisplayName, b.DisplayName),
+			cmp.Compare(a.Version, b.Version),
+		)
+	})
+
+	return apkInfos
+}
+
+func reworkPluginName(name string) string {
+	// Rework the name to be lowercase, replace spaces with underscores and dots with underscores
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, " ", "_")
+	name = strings.ReplaceAll(name, ".", "_")
+
+	// If the name end with _plugin_plugin or _app_app, remove the duplicate suffix
+	name = strings.ReplaceAll(name, "_plugin_plugin", "_plugin")
+	name = strings.ReplaceAll(name, "_app_app", "_app")
+	return name
+}
@@ -0,0 +1,188 @@
+package packager
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemoveOlderPluginVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		apkInfos []ApkInfo
+		want     []string
+	}{
+		{
+			name: "older first",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "Android", Revision: "1", ApkPath: "a1.apk"},
+				{Package: "com.a", Platform: "Android", Revision: "2", ApkPath: "a2.apk"},
+				{Package: "com.b", Platform: "Android", Revision: "1", ApkPath: "b1.apk"},
+			},
+			want: []string{"a2.apk", "b1.apk"},
+		},
+		{
+			name: "newer first",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "Android", Revision: "3", ApkPath: "a3.apk"},
+				{Package: "com.a", Platform: "Android", Revision: "1", ApkPath: "a1.apk"},
+				{Package: "com.a", Platform: "Android", Revision: "2", ApkPath: "a2.apk"},
+			},
+			want: []string{"a3.apk"},
+		},
+		{
+			name: "three revisions, oldest first",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "Android", Revision: "1", ApkPath: "a1.apk"},
+				{Package: "com.a", Platform: "Android", Revision: "2", ApkPath: "a2.apk"},
+				{Package: "com.a", Platform: "Android", Revision: "10", ApkPath: "a10.apk"},
+			},
+			want: []string{"a10.apk"},
+		},
+		{
+			name: "same revision",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "iOS", Revision: "1", ApkPath: "x.ipa"},
+				{Package: "com.a", Platform: "iOS", Revision: "1", ApkPath: "hidden.ipa"},
+			},
+			want: []string{"x.ipa"},
+		},
+		{
+			name: "other platform",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "Android", Revision: "1", ApkPath: "a.apk"},
+				{Package: "com.a", Platform: "iOS", Revision: "2", ApkPath: "a.ipa"},
+			},
+			want: []string{"a.apk", "a.ipa"},
+		},
+		{
+			name: "same label",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", Platform: "Android", DisplayName: "Same", Revision: "1", ApkPath: "a.apk"},
+				{Package: "com.b", Platform: "Android", DisplayName: "Same", Revision: "2", ApkPath: "b.apk"},
+			},
+			want: []string{"a.apk", "b.apk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apkInfos, err := RemoveOlderPluginVersions(nil, tt.apkInfos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, apkInfo := range apkInfos {
+				got = append(got, apkInfo.ApkPath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("RemoveOlderPluginVersions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginFilePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		apkInfos []ApkInfo
+		// Files that exist in the directory
+		existing []string
+		want     []string
+	}{
+		{
+			name: "own labels",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", DisplayName: "Alpha", Type: "plugin", ApkPath: "a.apk"},
+				{Package: "com.b", DisplayName: "Beta App", Type: "app", ApkPath: "sub/b.APK"},
+				{Package: "com.c", DisplayName: "Gamma", Type: "app", ApkPath: "c.apk"},
+			},
+			want: []string{"alpha_plugin.apk", "sub/beta_app.apk", "gamma_app.apk"},
+		},
+		{
+			name: "label collision falls back to package names",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", DisplayName: "Same", Type: "plugin", ApkPath: "a.apk"},
+				{Package: "com.b", DisplayName: "Same", Type: "plugin", ApkPath: "b.apk"},
+				{Package: "com.c", DisplayName: "Same", Type: "app", ApkPath: "c.apk"},
+			},
+			want: []string{"com_a_plugin.apk", "com_b_plugin.apk", "same_app.apk"},
+		},
+		{
+			name: "same package name gets a number",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", DisplayName: "Same", Type: "plugin", ApkPath: "a.apk"},
+				{Package: "com.a", DisplayName: "Same", Type: "plugin", ApkPath: "a2.apk"},
+			},
+			want: []string{"com_a_plugin.apk", "com_a_plugin_2.apk"},
+		},
+		{
+			name: "existing file gets a number",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", DisplayName: "Alpha", Type: "plugin", ApkPath: "a.apk"},
+			},
+			existing: []string{"alpha_plugin.apk", "alpha_plugin_2.apk"},
+			want:     []string{"alpha_plugin_3.apk"},
+		},
+		{
+			name: "files of the batch are free",
+			apkInfos: []ApkInfo{
+				{Package: "com.a", DisplayName: "Beta", Type: "plugin", ApkPath: "alpha_plugin.apk"},
+				{Package: "com.b", DisplayName: "Alpha", Type: "plugin", ApkPath: "beta_plugin.apk"},
+			},
+			existing: []string{"alpha_plugin.apk", "beta_plugin.apk"},
+			want:     []string{"beta_plugin.apk", "alpha_plugin.apk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			files := map[string]string{}
+			for _, name := range tt.existing {
+				files[name] = "existing"
+			}
+			writeTestFiles(t, ".", files)
+
+			got, err := pluginFilePaths(tt.apkInfos, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("pluginFilePaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreePluginFilePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		apkPath  string
+		apkInfos []ApkInfo
+		replaced []ApkInfo
+		existing []string
+		want     string
+	}{
+		{name: "free", apkPath: "a.apk", want: "a.apk"},
+		{name: "existing file", apkPath: "a.apk", existing: []string{"a.apk"}, want: "a_2.apk"},
+		{name: "existing numbered files", apkPath: "a.apk", existing: []string{"a.apk", "a_2.apk"}, want: "a_3.apk"},
+		{name: "file of another plugin", apkPath: "a.apk", apkInfos: []ApkInfo{{ApkPath: "a.apk"}}, want: "a_2.apk"},
+		{name: "file of a replaced plugin", apkPath: "a.apk", replaced: []ApkInfo{{ApkPath: "a.apk"}}, existing: []string{"a.apk"}, want: "a.apk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{}
+			for _, name := range tt.existing {
+				files[name] = "existing"
+			}
+			writeTestFiles(t, dir, files)
+
+			got, err := freePluginFilePath(tt.apkPath, tt.apkInfos, tt.replaced, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("freePluginFilePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
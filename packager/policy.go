@@ -0,0 +1,148 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Default policy file, used if it exists in the plugins directory
+const DefaultPolicyFilename = "policy.json"
+
+// Actions for build issues (debuggable, test-only and outdated target SDK builds)
+const (
+	policyActionWarn = "warn"
+	policyActionFail = "fail"
+)
+
+// Policy for which plugins are allowed to be packaged
+type Policy struct {
+	// Permissions that are not allowed, e.g. "READ_SMS" or "android.permission.READ_SMS"
+	DeniedPermissions []string `json:"deniedPermissions"`
+	// Lowest allowed targetSdkVersion, 0 allows all
+	MinTargetSdk int `json:"minTargetSdk"`
+	// What to do with debuggable, test-only and outdated target SDK builds: "warn" (default) or "fail"
+	BuildIssues string `json:"buildIssues"`
+}
+
+// PolicyViolation describes why a package is not allowed by the policy
+type PolicyViolation struct {
+	ApkInfo ApkInfo
+	Reason  string
+	// Fatal violations deny packaging, others are only warnings
+	Fatal bool
+}
+
+// Load policy from file. If the file does not exist, an empty policy is returned.
+func loadPolicy(filePath string) (Policy, error) {
+	policy := Policy{}
+
+	if filePath == "" {
+		return policy, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) && filePath == DefaultPolicyFilename {
+		// Default policy file is optional
+		return policy, nil
+	}
+	if err != nil {
+		return policy, fmt.Errorf("error reading policy file: %w", err)
+	}
+
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		return policy, fmt.Errorf("error parsing policy file %s: %w", filePath, err)
+	}
+
+	switch policy.BuildIssues {
+	case "", policyActionWarn, policyActionFail:
+	default:
+		return policy, fmt.Errorf("invalid buildIssues value in policy file %s: %s", filePath, policy.BuildIssues)
+	}
+
+	return policy, nil
+}
+
+// Check apk against the policy and return the violations
+func (p Policy) Check(apkInfo ApkInfo) []PolicyViolation {
+	violations := []PolicyViolation{}
+
+	for _, permission := range apkInfo.Permissions {
+		if p.isPermissionDenied(permission) {
+			violations = append(violations, PolicyViolation{
+				ApkInfo: apkInfo,
+				Reason:  "requests denied permission " + permission,
+				Fatal:   true,
+			})
+		}
+	}
+
+	// Build issues are Android only, iOS and Windows rows have no target SDK
+	if apkInfo.Platform != "Android" {
+		return violations
+	}
+
+	// Build issues are fatal only if configured so
+	buildIssueFatal := p.BuildIssues == policyActionFail
+	if apkInfo.Debuggable {
+		violations = append(violations, PolicyViolation{
+			ApkInfo: apkInfo,
+			Reason:  "is a debuggable build",
+			Fatal:   buildIssueFatal,
+		})
+	}
+	if apkInfo.TestOnly {
+		violations = append(violations, PolicyViolation{
+			ApkInfo: apkInfo,
+			Reason:  "is a test-only build",
+			Fatal:   buildIssueFatal,
+		})
+	}
+	if p.MinTargetSdk > 0 && apkInfo.TargetSdk < p.MinTargetSdk {
+		violations = append(violations, PolicyViolation{
+			ApkInfo: apkInfo,
+			Reason:  "targets SDK " + strconv.Itoa(apkInfo.TargetSdk) + ", lower than required " + strconv.Itoa(p.MinTargetSdk),
+			Fatal:   buildIssueFatal,
+		})
+	}
+
+	return violations
+}
+
+// Check if the permission is denied. Permissions without a prefix match the android.permission namespace.
+func (p Policy) isPermissionDenied(permission string) bool {
+	for _, denied := range p.DeniedPermissions {
+		if !strings.Contains(denied, ".") {
+			denied = "android.permission." + denied
+		}
+		if permission == denied {
+			return true
+		}
+	}
+	return false
+}
+
+// Check all apks against the policy, print warnings and return an error listing every fatal violation
+func (p Policy) Enforce(apkInfos []ApkInfo) error {
+	reasons := []string{}
+
+	for _, apkInfo := range apkInfos {
+		for _, violation := range p.Check(apkInfo) {
+			if !violation.Fatal {
+				fmt.Println("Warning:", violation.ApkInfo.ApkPath, "("+violation.ApkInfo.DisplayName+")", violation.Reason)
+				continue
+			}
+			fmt.Println("Policy violation:", violation.ApkInfo.ApkPath, "("+violation.ApkInfo.DisplayName+")", violation.Reason)
+			reasons = append(reasons, violation.ApkInfo.ApkPath+" "+violation.Reason)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("packages not allowed by policy: %s", strings.Join(reasons, "; "))
+}
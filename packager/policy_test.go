@@ -0,0 +1,119 @@
+package packager
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{
+		DeniedPermissions: []string{"READ_SMS", "com.example.permission.SECRET"},
+		MinTargetSdk:      30,
+		BuildIssues:       policyActionFail,
+	}
+	tests := []struct {
+		name    string
+		apkInfo ApkInfo
+		want    []string
+	}{
+		{
+			name:    "allowed apk",
+			apkInfo: ApkInfo{Platform: "Android", TargetSdk: 33, Permissions: []string{"android.permission.INTERNET"}},
+			want:    []string{},
+		},
+		{
+			name: "denied permissions",
+			apkInfo: ApkInfo{Platform: "Android", TargetSdk: 33, Permissions: []string{
+				"android.permission.READ_SMS",
+				"com.example.permission.SECRET",
+				"com.example.permission.READ_SMS",
+			}},
+			want: []string{
+				"requests denied permission android.permission.READ_SMS",
+				"requests denied permission com.example.permission.SECRET",
+			},
+		},
+		{
+			name:    "build issues",
+			apkInfo: ApkInfo{Platform: "Android", TargetSdk: 29, Debuggable: true, TestOnly: true},
+			want: []string{
+				"is a debuggable build",
+				"is a test-only build",
+				"targets SDK 29, lower than required 30",
+			},
+		},
+		{
+			name:    "ipa",
+			apkInfo: ApkInfo{Platform: "iOS", ApkPath: "app.ipa"},
+			want:    []string{},
+		},
+		{
+			name:    "msi",
+			apkInfo: ApkInfo{Platform: "Windows", ApkPath: "plugin.msi"},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, violation := range policy.Check(tt.apkInfo) {
+				if !violation.Fatal {
+					t.Errorf("violation %q is not fatal", violation.Reason)
+				}
+				got = append(got, violation.Reason)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyEnforce(t *testing.T) {
+	policy := Policy{MinTargetSdk: 30, BuildIssues: policyActionFail}
+	apkInfos := []ApkInfo{
+		{Platform: "Android", ApkPath: "new.apk", TargetSdk: 33},
+		{Platform: "iOS", ApkPath: "app.ipa"},
+		{Platform: "Windows", ApkPath: "plugin.msi"},
+	}
+	err := policy.Enforce(apkInfos)
+	if err != nil {
+		t.Errorf("Enforce() error = %v", err)
+	}
+
+	// Build issues are only warnings by default
+	warnPolicy := Policy{MinTargetSdk: 30}
+	err = warnPolicy.Enforce(append(apkInfos, ApkInfo{Platform: "Android", ApkPath: "old.apk", TargetSdk: 29}))
+	if err != nil {
+		t.Errorf("Enforce() error = %v", err)
+	}
+
+	err = policy.Enforce(append(apkInfos, ApkInfo{Platform: "Android", ApkPath: "old.apk", TargetSdk: 29}))
+	if err == nil || !strings.Contains(err.Error(), "old.apk targets SDK 29") || strings.Contains(err.Error(), "app.ipa") {
+		t.Errorf("Enforce() error = %v, want error for old.apk only", err)
+	}
+}
+
+func TestAuditReportPlatforms(t *testing.T) {
+	plugins := []auditedPlugin{
+		{ApkInfo: ApkInfo{Platform: "Android", DisplayName: "Plugin", TargetSdk: 33, Abis: []string{"arm64-v8a"}}},
+		{ApkInfo: ApkInfo{Platform: "iOS", DisplayName: "App"}},
+	}
+	report := createAuditReport(plugins, Policy{MinTargetSdk: 30})
+	android, ios, _ := strings.Cut(report, "App (")
+
+	for _, line := range []string{"  SDK: min 0, target 33\n", "  Debuggable: false, test-only: false\n", "  ABIs: arm64-v8a\n"} {
+		if !strings.Contains(android, line) {
+			t.Errorf("Android report does not contain %q", line)
+		}
+	}
+	for _, prefix := range []string{"  SDK:", "  Debuggable:", "  ABIs:", "  Warning:"} {
+		if strings.Contains(ios, prefix) {
+			t.Errorf("iOS report contains %q", prefix)
+		}
+	}
+	if !strings.Contains(ios, "  Policy: OK\n") {
+		t.Error("iOS report does not contain policy result")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package packager
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Check if the process is running. Signal 0 checks the process without sending a signal.
+func isProcessRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package packager
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Exit code of a process that has not exited
+const stillActive = 259
+
+// Check if the process is running
+func isProcessRunning(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Process of another user
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(handle)
+
+	var exitCode uint32
+	err = syscall.GetExitCodeProcess(handle, &exitCode)
+	return err != nil || exitCode == stillActive
+}
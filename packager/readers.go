@@ -0,0 +1,273 @@
+package packager
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+	"github.com/pvarki/golang-tak-taktool/manifest"
+
+	"github.com/avast/apkparser"
+)
+
+// Built-in readers, custom readers registered later take precedence
+func init() {
+	artifact.Register(apkReader{})
+	artifact.Register(ipaReader{})
+	artifact.Register(msiReader{})
+}
+
+// Reader for Android apps and ATAK plugins (.apk)
+type apkReader struct{}
+
+func (apkReader) Name() string { return "apk" }
+
+func (apkReader) Detect(path string) bool { return isApkFile(path) }
+
+func (r apkReader) Read(apkPath string) (ApkInfo, error) {
+	f, err := os.Open(apkPath)
+	if err != nil {
+		return ApkInfo{}, &manifest.ParseError{Stage: manifest.StageZip, Err: err}
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return ApkInfo{}, &manifest.ParseError{Stage: manifest.StageZip, Err: err}
+	}
+	return r.ReadData(apkPath, f, fileInfo.Size())
+}
+
+func (apkReader) ReadData(apkPath string, r io.ReaderAt, size int64) (ApkInfo, error) {
+	apkZip, err := apkparser.OpenZipReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return ApkInfo{}, &manifest.ParseError{Stage: manifest.StageZip, Err: err}
+	}
+	defer apkZip.Close()
+
+	apkManifest, err := manifest.ParseZip(apkZip)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+
+	apkData := apkInfoFromManifest(apkManifest)
+
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return ApkInfo{}, &manifest.ParseError{Stage: manifest.StageZip, Err: err}
+	}
+
+	// Read bundled native libraries and supported ABIs
+	apkData.Abis, apkData.NativeLibraries = readNativeLibraries(zipReader)
+
+	// Read ATAK plugin extensions. They are only details, so the package is kept without them if plugin.xml is broken.
+	pluginDescriptor, err := manifest.ParsePluginDescriptorZip(apkZip)
+	if err != nil {
+		fmt.Println("Warning:", apkPath, "has an invalid plugin descriptor, extensions are not listed:", err)
+	} else if pluginDescriptor != nil {
+		apkData.Extensions = pluginDescriptor.Extensions
+	}
+
+	// Read signer certificate fingerprint
+	apkData.SignerFingerprint, err = readSignerFingerprint(r, size)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("error reading signature: %w", err)
+	}
+
+	// Read png icon while the apk is open
+	// TODO
+	// Check image size and scale it down if needed to 30% of the original size
+	// Repeat until the image size is e.g. under 50kb
+	if strings.Contains(apkData.IconPath, ".png") {
+		apkData.IconData, err = readZipFileByName(zipReader, apkData.IconPath)
+		if err != nil {
+			return ApkInfo{}, fmt.Errorf("error reading icon: %w", err)
+		}
+	}
+
+	return apkData, nil
+}
+
+func (apkReader) Icon(apkPath string, apkInfo ApkInfo) ([]byte, error) {
+	// TODO Might be possible to convert android xml icon to png with some library
+	if !strings.Contains(apkInfo.IconPath, ".png") {
+		return nil, nil
+	}
+	return readZipIcon(apkPath, apkInfo.IconPath)
+}
+
+func (apkReader) Hash(apkPath string) (string, error) { return calculateHash(apkPath) }
+
+// Reader for iOS apps (.ipa)
+type ipaReader struct{}
+
+func (ipaReader) Name() string { return "ipa" }
+
+func (ipaReader) Detect(path string) bool { return isIpaFile(path) }
+
+func (ipaReader) Read(ipaPath string) (ApkInfo, error) { return getIpaData(ipaPath) }
+
+func (ipaReader) Icon(ipaPath string, ipaInfo ApkInfo) ([]byte, error) {
+	if ipaInfo.IconPath == "" {
+		return nil, nil
+	}
+	iconData, err := readZipIcon(ipaPath, ipaInfo.IconPath)
+	if err != nil || iconData == nil {
+		return nil, err
+	}
+	// iOS icons are usually optimized pngs that need to be converted first
+	return normalizeIosPng(iconData)
+}
+
+func (ipaReader) Hash(ipaPath string) (string, error) { return calculateHash(ipaPath) }
+
+// Reader for WinTAK plugin installers (.msi)
+type msiReader struct{}
+
+func (msiReader) Name() string { return "msi" }
+
+func (msiReader) Detect(path string) bool { return isMsiFile(path) }
+
+func (msiReader) Read(msiPath string) (ApkInfo, error) { return getMsiData(msiPath) }
+
+func (msiReader) Icon(msiPath string, _ ApkInfo) ([]byte, error) { return getMsiIcon(msiPath) }
+
+func (msiReader) Hash(msiPath string) (string, error) { return calculateHash(msiPath) }
+
+// Read package information with the reader and add the path, size and hash of the file
+func readArtifact(reader artifact.Reader, filePath string) (ApkInfo, error) {
+	// Open the file only once if the reader can read it from an open file
+	if dataReader, ok := reader.(artifact.DataReader); ok {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return ApkInfo{}, &manifest.ParseError{Stage: manifest.StageZip, Err: err}
+		}
+		defer f.Close()
+
+		size, hash, err := hashFile(f)
+		if err != nil {
+			return ApkInfo{}, err
+		}
+		info, err := dataReader.ReadData(filePath, f, size)
+		if err != nil {
+			return ApkInfo{}, err
+		}
+		info.Format = reader.Name()
+		info.ApkPath = cleanupValue(filePath)
+		info.Size = int(size)
+		info.Hash = hash
+		return info, nil
+	}
+
+	info, err := reader.Read(filePath)
+	if err != nil {
+		return ApkInfo{}, err
+	}
+	info.Format = reader.Name()
+
+	// Remove commas from file path
+	info.ApkPath = cleanupValue(filePath)
+
+	// Calculate size of the file
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("error getting file info: %w", err)
+	}
+	info.Size = int(fileInfo.Size())
+
+	// Calculate hash SHA-256 for the file
+	info.Hash, err = reader.Hash(filePath)
+	if err != nil {
+		return ApkInfo{}, fmt.Errorf("error calculating hash: %w", err)
+	}
+
+	return info, nil
+}
+
+// Result of reading a package file, reader is nil if the file is not a package file
+type readResult struct {
+	reader artifact.Reader
+	info   ApkInfo
+	err    error
+}
+
+// Read package files with a pool of workers. Results are in the same order as the files.
+// Cache is used if it is not nil.
+func readArtifacts(files []string, jobs int, cache *metadataCache) []readResult {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	results := make([]readResult, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for range min(jobs, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				reader := artifact.ForFile(files[i])
+				if reader == nil {
+					continue
+				}
+				var info ApkInfo
+				var err error
+				if cache != nil {
+					info, err = cache.readArtifact(reader, files[i])
+				} else {
+					info, err = readArtifact(reader, files[i])
+				}
+				results[i] = readResult{reader: reader, info: info, err: err}
+			}
+		}()
+	}
+
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// Calculate SHA-256 hash of the open file by streaming it, and return its size and hash
+func hashFile(f io.Reader) (int64, string, error) {
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("error reading file: %w", err)
+	}
+	return size, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Read icon file from a zip package, returns nil if the file is not found
+func readZipIcon(zipPath, iconPath string) ([]byte, error) {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip: %w", err)
+	}
+	defer zipReader.Close()
+
+	return readZipFileByName(&zipReader.Reader, iconPath)
+}
+
+// Read file from an opened zip, returns nil if the file is not found
+func readZipFileByName(zipReader *zip.Reader, name string) ([]byte, error) {
+	for _, zipFile := range zipReader.File {
+		if zipFile.Name != name {
+			continue
+		}
+
+		return readZipFile(zipFile)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,56 @@
+package packager_test
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+	"github.com/pvarki/golang-tak-taktool/packager"
+)
+
+// Reader of a custom package type, registered like a program embedding taktool would
+type testPluginReader struct{}
+
+func (testPluginReader) Name() string            { return "testplugin" }
+func (testPluginReader) Detect(path string) bool { return strings.HasSuffix(path, ".testplugin") }
+func (testPluginReader) Read(path string) (artifact.Info, error) {
+	return artifact.Info{Platform: "Android", Type: "plugin", Package: "com.example.test", DisplayName: "Test", Version: "1.0", Revision: "7", OsReq: 1}, nil
+}
+func (testPluginReader) Icon(path string, info artifact.Info) ([]byte, error) { return nil, nil }
+func (testPluginReader) Hash(path string) (string, error)                     { return artifact.HashFile(path) }
+
+func TestRegisteredReader(t *testing.T) {
+	artifact.Register(testPluginReader{})
+	t.Chdir(t.TempDir())
+	err := os.WriteFile("custom.testplugin", []byte("plugin"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = packager.PackagePlugins(packager.PluginsOptions{RenamePlugins: true, NoCache: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infz, err := zip.OpenReader("product.infz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer infz.Close()
+	f, err := infz.Open("product.inf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	productInf, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Android,plugin,com.example.test,Test,1.0,7,test_plugin.testplugin,"
+	if !strings.Contains(string(productInf), want) {
+		t.Errorf("product.inf = %q, want row starting with %q", productInf, want)
+	}
+}
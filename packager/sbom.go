@@ -0,0 +1,180 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const sbomFilename = "product.cdx.json"
+
+// CycloneDX 1.5 JSON document, https://cyclonedx.org/docs/1.5/json/
+type cdxBom struct {
+	BomFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string         `json:"type"`
+	BomRef      string         `json:"bom-ref,omitempty"`
+	Supplier    *cdxSupplier   `json:"supplier,omitempty"`
+	Name        string         `json:"name"`
+	Version     string         `json:"version,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Hashes      []cdxHash      `json:"hashes,omitempty"`
+	Properties  []cdxProperty  `json:"properties,omitempty"`
+	Components  []cdxComponent `json:"components,omitempty"`
+}
+
+type cdxSupplier struct {
+	Name string `json:"name"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Write CycloneDX SBOM of the apks
+func writeSbom(w io.Writer, apkInfos []ApkInfo) error {
+	return encodeSbom(w, createSbom(apkInfos, time.Now()))
+}
+
+// Write CycloneDX SBOM of the apks, keeping the components of unchanged apks from the previous SBOM.
+// Used when only some apks are read, as product.inf does not have all details of the others.
+func updateSbom(w io.Writer, apkInfos []ApkInfo, previous []cdxComponent) error {
+	bom := createSbom(apkInfos, time.Now())
+	for i, component := range bom.Components {
+		for _, previousComponent := range previous {
+			if previousComponent.BomRef == component.BomRef && slices.Equal(previousComponent.Hashes, component.Hashes) {
+				bom.Components[i] = previousComponent
+				break
+			}
+		}
+	}
+	return encodeSbom(w, bom)
+}
+
+// Read components of the SBOM file, returns nil if the file does not exist
+func readSbomComponents(sbomPath string) ([]cdxComponent, error) {
+	data, err := os.ReadFile(sbomPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading SBOM: %w", err)
+	}
+
+	bom := cdxBom{}
+	err = json.Unmarshal(data, &bom)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SBOM %s: %w", sbomPath, err)
+	}
+	return bom.Components, nil
+}
+
+func encodeSbom(w io.Writer, bom cdxBom) error {
+	data, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding SBOM: %w", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	return nil
+}
+
+// Create CycloneDX SBOM describing every apk in the repository
+func createSbom(apkInfos []ApkInfo, timestamp time.Time) cdxBom {
+	bom := cdxBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.New().String(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: timestamp.UTC().Format(time.RFC3339),
+			Tools: cdxTools{
+				Components: []cdxComponent{{Type: "application", Name: "taktool"}},
+			},
+			Component: cdxComponent{Type: "application", Name: proructInfzFilename},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for _, apkInfo := range sortApkInfos(apkInfos) {
+		component := cdxComponent{
+			Type:        "application",
+			BomRef:      apkInfo.Package + "@" + apkInfo.Revision,
+			Name:        apkInfo.Package,
+			Version:     apkInfo.Version,
+			Description: apkInfo.DisplayName,
+			Hashes:      []cdxHash{{Alg: "SHA-256", Content: apkInfo.Hash}},
+			Properties: []cdxProperty{
+				{Name: "taktool:platform", Value: apkInfo.Platform},
+				{Name: "taktool:type", Value: apkInfo.Type},
+				{Name: "taktool:revision", Value: apkInfo.Revision},
+				{Name: "taktool:file", Value: apkInfo.ApkPath},
+				{Name: "taktool:size", Value: strconv.Itoa(apkInfo.Size)},
+				{Name: "taktool:signerFingerprint", Value: apkInfo.SignerFingerprint},
+			},
+		}
+
+		if apkInfo.Vendor != "" {
+			component.Supplier = &cdxSupplier{Name: apkInfo.Vendor}
+		}
+
+		for _, permission := range apkInfo.Permissions {
+			component.Properties = append(component.Properties, cdxProperty{Name: "android:permission", Value: permission})
+		}
+
+		for _, extension := range apkInfo.Extensions {
+			component.Properties = append(component.Properties, cdxProperty{Name: "atak:extension", Value: extension.Type + " " + extension.Impl})
+		}
+
+		// Bundled native libraries as subcomponents
+		for _, library := range apkInfo.NativeLibraries {
+			component.Components = append(component.Components, cdxComponent{
+				Type:   "library",
+				BomRef: component.BomRef + "/" + library,
+				Name:   path.Base(library),
+				Properties: []cdxProperty{
+					{Name: "taktool:path", Value: library},
+					{Name: "android:abi", Value: path.Base(path.Dir(library))},
+				},
+			})
+		}
+
+		bom.Components = append(bom.Components, component)
+	}
+
+	return bom
+}
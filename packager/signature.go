@@ -0,0 +1,211 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// APK Signature Scheme block ids, https://source.android.com/docs/security/features/apksigning/v2
+const (
+	apkSigBlockMagic = "APK Sig Block 42"
+	apkSigV2BlockID  = 0x7109871a
+	apkSigV3BlockID  = 0xf05368c0
+)
+
+var errNoSigningBlock = errors.New("no APK signing block")
+
+// Read SHA-256 fingerprint of the first signer certificate of the apk.
+// APK Signature Scheme v3 and v2 blocks are preferred, v1 (JAR) signatures are used as a fallback.
+// Returns empty string if the apk is not signed.
+func readSignerFingerprint(r io.ReaderAt, size int64) (string, error) {
+	cert, err := readSigningBlockCertificate(r, size)
+	if err != nil && !errors.Is(err, errNoSigningBlock) {
+		return "", fmt.Errorf("error reading APK signing block: %w", err)
+	}
+
+	// Fall back to v1 signature
+	if cert == nil {
+		zipReader, err := zip.NewReader(r, size)
+		if err != nil {
+			return "", fmt.Errorf("error reading zip: %w", err)
+		}
+		cert, err = readJarSignatureCertificate(zipReader)
+		if err != nil {
+			return "", fmt.Errorf("error reading v1 signature: %w", err)
+		}
+	}
+
+	if cert == nil {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(cert)), nil
+}
+
+// Read first certificate from the v3 or v2 signature in the APK signing block
+func readSigningBlockCertificate(r io.ReaderAt, size int64) ([]byte, error) {
+	centralDirOffset, err := findCentralDirectoryOffset(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signing block ends right before the central directory with block size and magic
+	if centralDirOffset < 24 {
+		return nil, errNoSigningBlock
+	}
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, centralDirOffset-24); err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil, errNoSigningBlock
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint64(footer[:8]))
+	if blockSize < 24 || blockSize+8 > centralDirOffset {
+		return nil, fmt.Errorf("invalid signing block size %d", blockSize)
+	}
+	block := make([]byte, blockSize-24)
+	if _, err := r.ReadAt(block, centralDirOffset-blockSize); err != nil {
+		return nil, err
+	}
+
+	// Block contains id-value pairs prefixed with uint64 length
+	pairs := map[uint32][]byte{}
+	for len(block) >= 12 {
+		pairLen := binary.LittleEndian.Uint64(block[:8])
+		if pairLen < 4 || pairLen > uint64(len(block)-8) {
+			return nil, fmt.Errorf("invalid signing block pair length %d", pairLen)
+		}
+		pairs[binary.LittleEndian.Uint32(block[8:12])] = block[12 : 8+pairLen]
+		block = block[8+pairLen:]
+	}
+
+	for _, id := range []uint32{apkSigV3BlockID, apkSigV2BlockID} {
+		if value, ok := pairs[id]; ok {
+			return readSignerCertificate(value)
+		}
+	}
+
+	return nil, errNoSigningBlock
+}
+
+// Read first certificate of the first signer from v2 or v3 signature block value
+func readSignerCertificate(value []byte) ([]byte, error) {
+	// signers > signer > signed data > certificates > certificate
+	signers, _, err := readLengthPrefixed(value)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signers: %w", err)
+	}
+	signer, _, err := readLengthPrefixed(signers)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signer: %w", err)
+	}
+	signedData, _, err := readLengthPrefixed(signer)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signed data: %w", err)
+	}
+	_, rest, err := readLengthPrefixed(signedData) // digests
+	if err != nil {
+		return nil, fmt.Errorf("error reading digests: %w", err)
+	}
+	certificates, _, err := readLengthPrefixed(rest)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificates: %w", err)
+	}
+	certificate, _, err := readLengthPrefixed(certificates)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// Read uint32 length prefixed value and return it with the remaining data
+func readLengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	length := binary.LittleEndian.Uint32(data[:4])
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return data[4 : 4+length], data[4+length:], nil
+}
+
+// Find the offset of zip central directory from the end of central directory record
+func findCentralDirectoryOffset(r io.ReaderAt, size int64) (int64, error) {
+	// End of central directory record is 22 bytes and may be followed by a comment of up to 65535 bytes
+	tailSize := min(size, 22+65535)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil {
+		return 0, err
+	}
+
+	eocd := bytes.LastIndex(tail, []byte{'P', 'K', 0x05, 0x06})
+	if eocd < 0 || eocd+22 > len(tail) {
+		return 0, errors.New("end of central directory not found")
+	}
+
+	return int64(binary.LittleEndian.Uint32(tail[eocd+16 : eocd+20])), nil
+}
+
+// PKCS #7 structures of a JAR signature block file
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// Read first certificate from v1 (JAR) signature block file in META-INF
+func readJarSignatureCertificate(zipReader *zip.Reader) ([]byte, error) {
+	for _, zipFile := range zipReader.File {
+		name := strings.ToUpper(zipFile.Name)
+		if !strings.HasPrefix(name, "META-INF/") || strings.Count(name, "/") != 1 {
+			continue
+		}
+		if !strings.HasSuffix(name, ".RSA") && !strings.HasSuffix(name, ".DSA") && !strings.HasSuffix(name, ".EC") {
+			continue
+		}
+
+		rc, err := zipFile.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening file: %w", err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+
+		var contentInfo pkcs7ContentInfo
+		if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", zipFile.Name, err)
+		}
+		var signedData pkcs7SignedData
+		if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+			return nil, fmt.Errorf("error parsing signed data of %s: %w", zipFile.Name, err)
+		}
+
+		// Certificates is a set of DER certificates, take the first one as is
+		var certificate asn1.RawValue
+		if _, err := asn1.Unmarshal(signedData.Certificates.Bytes, &certificate); err != nil {
+			return nil, fmt.Errorf("error parsing certificate of %s: %w", zipFile.Name, err)
+		}
+		return certificate.FullBytes, nil
+	}
+
+	return nil, nil
+}
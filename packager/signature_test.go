@@ -0,0 +1,214 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// Build zip of the files by name
+func buildZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(files[name])
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Insert APK signing block with the id-value pairs before the central directory of the zip
+func withSigningBlock(t *testing.T, zipData []byte, pairs ...[]byte) []byte {
+	t.Helper()
+	centralDirOffset, err := findCentralDirectoryOffset(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := slices.Concat(pairs...)
+	blockSize := uint64(len(content) + 8 + len(apkSigBlockMagic))
+	block := binary.LittleEndian.AppendUint64(nil, blockSize)
+	block = append(block, content...)
+	block = binary.LittleEndian.AppendUint64(block, blockSize)
+	block = append(block, apkSigBlockMagic...)
+
+	data := slices.Concat(zipData[:centralDirOffset], block, zipData[centralDirOffset:])
+	eocd := bytes.LastIndex(data, []byte{'P', 'K', 0x05, 0x06})
+	binary.LittleEndian.PutUint32(data[eocd+16:], uint32(centralDirOffset)+uint32(len(block)))
+	return data
+}
+
+// Id-value pair of the signing block
+func signingBlockPair(id uint32, value []byte) []byte {
+	pair := binary.LittleEndian.AppendUint64(nil, uint64(4+len(value)))
+	pair = binary.LittleEndian.AppendUint32(pair, id)
+	return append(pair, value...)
+}
+
+// Value prefixed with uint32 length
+func lengthPrefixed(values ...[]byte) []byte {
+	value := slices.Concat(values...)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(value))), value...)
+}
+
+// v2 or v3 signature block value with one signer and the certificate
+func signatureValue(certificate []byte) []byte {
+	digests := lengthPrefixed(lengthPrefixed([]byte("digest")))
+	certificates := lengthPrefixed(lengthPrefixed(certificate))
+	signedData := lengthPrefixed(digests, certificates)
+	signer := lengthPrefixed(signedData, lengthPrefixed(), lengthPrefixed([]byte("public key")))
+	return lengthPrefixed(signer)
+}
+
+// PKCS #7 signed data with the certificate as in META-INF/CERT.RSA
+func jarSignature(t *testing.T, certificate []byte) []byte {
+	t.Helper()
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{FullBytes: []byte{0x31, 0x00}},
+		ContentInfo:      asn1.RawValue{FullBytes: []byte{0x30, 0x00}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// DER value used as a certificate
+func testCertificate(t *testing.T, name string) []byte {
+	t.Helper()
+	certificate, err := asn1.Marshal(struct{ Name string }{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestReadSignerFingerprint(t *testing.T) {
+	v1 := testCertificate(t, "v1")
+	v2 := testCertificate(t, "v2")
+	v3 := testCertificate(t, "v3")
+	fingerprint := func(certificate []byte) string {
+		return fmt.Sprintf("%x", sha256.Sum256(certificate))
+	}
+	apk := buildZip(t, map[string][]byte{"AndroidManifest.xml": []byte("manifest")})
+	v1Apk := buildZip(t, map[string][]byte{
+		"AndroidManifest.xml":  []byte("manifest"),
+		"META-INF/CERT.RSA":    jarSignature(t, v1),
+		"META-INF/MANIFEST.MF": []byte("Manifest-Version: 1.0\r\n"),
+	})
+	const paddingID = 0x42726577
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "unsigned",
+			data: apk,
+			want: "",
+		},
+		{
+			name: "v1",
+			data: v1Apk,
+			want: fingerprint(v1),
+		},
+		{
+			name: "v2",
+			data: withSigningBlock(t, v1Apk, signingBlockPair(apkSigV2BlockID, signatureValue(v2))),
+			want: fingerprint(v2),
+		},
+		{
+			name: "v3 preferred over v2",
+			data: withSigningBlock(t, apk,
+				signingBlockPair(apkSigV2BlockID, signatureValue(v2)),
+				signingBlockPair(paddingID, make([]byte, 16)),
+				signingBlockPair(apkSigV3BlockID, signatureValue(v3)),
+			),
+			want: fingerprint(v3),
+		},
+		{
+			name: "no signature in signing block",
+			data: withSigningBlock(t, v1Apk, signingBlockPair(paddingID, make([]byte, 16))),
+			want: fingerprint(v1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSignerFingerprint(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("readSignerFingerprint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSignerFingerprintErrors(t *testing.T) {
+	apk := buildZip(t, map[string][]byte{"AndroidManifest.xml": []byte("manifest")})
+	value := signatureValue(testCertificate(t, "v2"))
+	invalidPair := binary.LittleEndian.AppendUint64(nil, 1000)
+	invalidPair = binary.LittleEndian.AppendUint32(invalidPair, apkSigV2BlockID)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "pair length",
+			data: withSigningBlock(t, apk, invalidPair),
+			want: "error reading APK signing block: invalid signing block pair length 1000",
+		},
+		{
+			name: "truncated signers",
+			data: withSigningBlock(t, apk, signingBlockPair(apkSigV2BlockID, value[:2])),
+			want: "error reading APK signing block: error reading signers: unexpected EOF",
+		},
+		{
+			name: "truncated certificate",
+			data: withSigningBlock(t, apk, signingBlockPair(apkSigV2BlockID, lengthPrefixed(lengthPrefixed(lengthPrefixed(
+				lengthPrefixed(), lengthPrefixed([]byte{10, 0, 0, 0}),
+			))))),
+			want: "error reading APK signing block: error reading certificate: unexpected EOF",
+		},
+		{
+			name: "not a zip",
+			data: []byte("not a zip"),
+			want: "error reading APK signing block: end of central directory not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSignerFingerprint(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("readSignerFingerprint() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
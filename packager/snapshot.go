@@ -0,0 +1,399 @@
+package packager
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Directory of the snapshots in the output directory. Every snapshot has product.infz, SBOM and the list of
+// package files, and the package files are archived once by hash in the objects directory.
+const snapshotsDirname = ".snapshots"
+
+const (
+	snapshotObjectsDirname = "objects"
+	snapshotFilename       = "snapshot.json"
+	snapshotIDFormat       = "20060102-150405"
+)
+
+// Snapshot of the repository
+type snapshot struct {
+	ID      string         `json:"id"`
+	Created time.Time      `json:"created"`
+	Files   []snapshotFile `json:"files"`
+	// Snapshot is the current product.infz, not saved
+	Current bool `json:"current,omitempty"`
+}
+
+// Package file referenced by product.infz of the snapshot
+type snapshotFile struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	Package  string `json:"package"`
+	Revision string `json:"revision"`
+}
+
+// Write a snapshot of product.infz and SBOM in the transaction, and archive the package files that are not archived yet
+func writeSnapshot(tx *transaction, apkInfos []ApkInfo, outDir string, productInfz, sbom []byte) error {
+	snapshotsDir := filepath.Join(outDir, snapshotsDirname)
+	objectsDir := filepath.Join(snapshotsDir, snapshotObjectsDirname)
+
+	created := time.Now().UTC()
+	id := created.Format(snapshotIDFormat)
+	for n := 2; fileExists(filepath.Join(snapshotsDir, id)); n++ {
+		id = fmt.Sprintf("%s-%d", created.Format(snapshotIDFormat), n)
+	}
+
+	snap := snapshot{ID: id, Created: created, Files: []snapshotFile{}}
+	for _, apkInfo := range sortApkInfos(apkInfos) {
+		snap.Files = append(snap.Files, snapshotFile{
+			Path:     apkInfo.ApkPath,
+			Hash:     apkInfo.Hash,
+			Package:  apkInfo.Package,
+			Revision: apkInfo.Revision,
+		})
+	}
+
+	// Packaging without changes does not add a snapshot
+	unchanged, err := isNewestSnapshot(outDir, snap.Files, productInfz)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		return nil
+	}
+
+	archived := map[string]bool{}
+	for _, apkInfo := range apkInfos {
+		objectPath := filepath.Join(objectsDir, apkInfo.Hash)
+		if archived[apkInfo.Hash] || fileExists(objectPath) {
+			continue
+		}
+		sourcePath := cmp.Or(apkInfo.SourcePath, filepath.Join(outDir, apkInfo.ApkPath))
+		err := tx.writeFile(objectPath, copyFile(sourcePath))
+		if err != nil {
+			return err
+		}
+		archived[apkInfo.Hash] = true
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot: %w", err)
+	}
+
+	snapshotDir := filepath.Join(snapshotsDir, id)
+	err = tx.writeFile(filepath.Join(snapshotDir, proructInfzFilename), writeBytes(productInfz))
+	if err != nil {
+		return err
+	}
+	err = tx.writeFile(filepath.Join(snapshotDir, sbomFilename), writeBytes(sbom))
+	if err != nil {
+		return err
+	}
+	return tx.writeFile(filepath.Join(snapshotDir, snapshotFilename), writeBytes(data))
+}
+
+// Check if the newest snapshot has the same files and product.infz
+func isNewestSnapshot(outDir string, files []snapshotFile, productInfz []byte) (bool, error) {
+	snapshots, err := readSnapshots(outDir)
+	if err != nil || len(snapshots) == 0 {
+		return false, err
+	}
+	newest := snapshots[0]
+	if !slices.Equal(newest.Files, files) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, snapshotsDirname, newest.ID, proructInfzFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading snapshot: %w", err)
+	}
+	return bytes.Equal(data, productInfz), nil
+}
+
+// Read snapshots of the output directory, newest first
+func readSnapshots(outDir string) ([]snapshot, error) {
+	snapshotsDir := filepath.Join(outDir, snapshotsDirname)
+	entries, err := os.ReadDir(snapshotsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshots: %w", err)
+	}
+
+	snapshots := []snapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == snapshotObjectsDirname {
+			continue
+		}
+		// Snapshot that was being pruned
+		if !fileExists(filepath.Join(snapshotsDir, entry.Name(), snapshotFilename)) {
+			continue
+		}
+		snap, err := readSnapshot(outDir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	slices.SortFunc(snapshots, func(a, b snapshot) int {
+		return cmp.Or(b.Created.Compare(a.Created), cmp.Compare(b.ID, a.ID))
+	})
+	return snapshots, nil
+}
+
+// Read snapshot by its ID
+func readSnapshot(outDir, id string) (snapshot, error) {
+	snap := snapshot{}
+	if id == "" || id != filepath.Base(id) || id == snapshotObjectsDirname {
+		return snap, fmt.Errorf("invalid snapshot %s", id)
+	}
+	snapshotPath := filepath.Join(outDir, snapshotsDirname, id, snapshotFilename)
+	data, err := os.ReadFile(snapshotPath)
+	if os.IsNotExist(err) {
+		return snap, fmt.Errorf("snapshot %s not found", id)
+	}
+	if err != nil {
+		return snap, fmt.Errorf("error reading snapshot: %w", err)
+	}
+
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return snap, fmt.Errorf("error parsing snapshot %s: %w", snapshotPath, err)
+	}
+	return snap, nil
+}
+
+// Print snapshots of the output directory, newest first. With JSON option, snapshots are printed as JSON.
+func PluginHistory(opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	snapshots, err := readSnapshots(outDir)
+	if err != nil {
+		return err
+	}
+
+	// Mark the snapshot of the current product.infz
+	currentInfz, err := os.ReadFile(filepath.Join(outDir, proructInfzFilename))
+	if err == nil {
+		for i, snap := range snapshots {
+			snapshotInfz, err := os.ReadFile(filepath.Join(outDir, snapshotsDirname, snap.ID, proructInfzFilename))
+			if err == nil && bytes.Equal(snapshotInfz, currentInfz) {
+				snapshots[i].Current = true
+				break
+			}
+		}
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(snapshots, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots")
+		return nil
+	}
+	for _, snap := range snapshots {
+		current := ""
+		if snap.Current {
+			current = " (current)"
+		}
+		fmt.Printf("%s  %s  %d plugins%s\n", snap.ID, snap.Created.Local().Format(time.DateTime), len(snap.Files), current)
+		for _, file := range snap.Files {
+			fmt.Printf("  %s revision %s  %s\n", file.Package, file.Revision, file.Path)
+		}
+	}
+	return nil
+}
+
+// Restore product.infz, SBOM and package files of the snapshot in one transaction
+func RollbackPlugins(id string, opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	unlock, err := lockDirectory(outDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(outDir)
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	snap, err := readSnapshot(outDir, id)
+	if err != nil {
+		return err
+	}
+	snapshotDir := filepath.Join(outDir, snapshotsDirname, id)
+	productInfz, err := os.ReadFile(filepath.Join(snapshotDir, proructInfzFilename))
+	if err != nil {
+		return fmt.Errorf("error reading snapshot: %w", err)
+	}
+	sbom, err := os.ReadFile(filepath.Join(snapshotDir, sbomFilename))
+	if err != nil {
+		return fmt.Errorf("error reading snapshot: %w", err)
+	}
+
+	currentInfos, err := readProductInfz(filepath.Join(outDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+
+	// Package files are restored from the archive
+	apkInfos, err := readProductInfz(filepath.Join(snapshotDir, proructInfzFilename))
+	if err != nil {
+		return err
+	}
+	for i, apkInfo := range apkInfos {
+		objectPath := filepath.Join(outDir, snapshotsDirname, snapshotObjectsDirname, apkInfo.Hash)
+		if !fileExists(objectPath) {
+			return fmt.Errorf("archived file of %s revision %s is missing", apkInfo.Package, apkInfo.Revision)
+		}
+		apkInfos[i].SourcePath = objectPath
+	}
+
+	err = inTransaction(outDir, func(tx *transaction) error {
+		// Remove package files that are not in the snapshot
+		for _, apkInfo := range currentInfos {
+			inSnapshot := slices.ContainsFunc(snap.Files, func(file snapshotFile) bool {
+				return file.Path == apkInfo.ApkPath && file.Hash == apkInfo.Hash
+			})
+			if inSnapshot {
+				continue
+			}
+			fmt.Println("Removing", apkInfo.DisplayName, "revision", apkInfo.Revision)
+			err := removePluginFile(tx, filepath.Join(outDir, apkInfo.ApkPath))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, apkInfo := range apkInfos {
+			filePath := filepath.Join(outDir, apkInfo.ApkPath)
+			if fileExists(filePath) {
+				hash, err := calculateHash(filePath)
+				if err != nil {
+					return err
+				}
+				if hash == apkInfo.Hash {
+					continue
+				}
+				err = tx.remove(filePath)
+				if err != nil {
+					return err
+				}
+			}
+
+			fmt.Println("Restoring", apkInfo.Package, "revision", apkInfo.Revision, "as", apkInfo.ApkPath)
+			err := tx.writeFile(filePath, copyFile(apkInfo.SourcePath))
+			if err != nil {
+				return err
+			}
+		}
+
+		return writeIndexFiles(tx, apkInfos, outDir, productInfz, sbom, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Rolled back to snapshot", id)
+	return nil
+}
+
+// Remove all but the newest snapshots, and the archived package files that no kept snapshot refers to.
+// The snapshot of the current product.infz is always kept.
+func PruneSnapshots(opts PluginsOptions) error {
+	outDir := cmp.Or(opts.OutDir, ".")
+	unlock, err := lockDirectory(outDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = recoverJournal(outDir)
+	if err != nil {
+		return fmt.Errorf("error recovering interrupted packaging: %w", err)
+	}
+
+	snapshots, err := readSnapshots(outDir)
+	if err != nil {
+		return err
+	}
+	currentInfz, err := os.ReadFile(filepath.Join(outDir, proructInfzFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading %s: %w", proructInfzFilename, err)
+	}
+
+	snapshotsDir := filepath.Join(outDir, snapshotsDirname)
+	kept := map[string]bool{}
+	currentFound := false
+	removed := 0
+	for i, snap := range snapshots {
+		snapshotDir := filepath.Join(snapshotsDir, snap.ID)
+		snapshotInfz, _ := os.ReadFile(filepath.Join(snapshotDir, proructInfzFilename))
+		current := !currentFound && currentInfz != nil && bytes.Equal(snapshotInfz, currentInfz)
+		currentFound = currentFound || current
+		if i < opts.KeepSnapshots || current {
+			for _, file := range snap.Files {
+				kept[file.Hash] = true
+			}
+			continue
+		}
+
+		// Without the snapshot file, a partly removed snapshot is not listed
+		err = os.Remove(filepath.Join(snapshotDir, snapshotFilename))
+		if err != nil {
+			return fmt.Errorf("error removing snapshot: %w", err)
+		}
+		err = os.RemoveAll(snapshotDir)
+		if err != nil {
+			return fmt.Errorf("error removing snapshot: %w", err)
+		}
+		removed++
+	}
+
+	objectsDir := filepath.Join(snapshotsDir, snapshotObjectsDirname)
+	objects, err := os.ReadDir(objectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading snapshots: %w", err)
+	}
+	freed := int64(0)
+	for _, object := range objects {
+		if kept[object.Name()] {
+			continue
+		}
+		objectInfo, err := object.Info()
+		if err == nil {
+			freed += objectInfo.Size()
+		}
+		err = os.Remove(filepath.Join(objectsDir, object.Name()))
+		if err != nil {
+			return fmt.Errorf("error removing archived file: %w", err)
+		}
+	}
+
+	fmt.Printf("Removed %d snapshots, %d bytes of archived plugins freed\n", removed, freed)
+	return nil
+}
+
+// Check if the file exists
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
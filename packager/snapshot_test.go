@@ -0,0 +1,35 @@
+package packager
+
+import (
+	"testing"
+)
+
+func TestWriteSnapshotUnchanged(t *testing.T) {
+	t.Chdir(t.TempDir())
+	plugin := ApkInfo{Package: "com.a", Platform: "Android", DisplayName: "A", Type: "plugin", Revision: "1", ApkPath: "a_plugin.apk", Hash: "h1", IconData: []byte{}}
+
+	checkSnapshots := func(want int) {
+		t.Helper()
+		snapshots, err := readSnapshots(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != want {
+			t.Errorf("snapshots = %d, want %d", len(snapshots), want)
+		}
+	}
+
+	packageTestPlugins(t, []ApkInfo{plugin})
+	checkSnapshots(1)
+
+	// Packaging the same files again does not add a snapshot
+	packageTestPlugins(t, []ApkInfo{plugin})
+	checkSnapshots(1)
+
+	// New revision is a new snapshot
+	plugin.Revision = "2"
+	plugin.ApkPath = "new.apk"
+	plugin.Hash = "h2"
+	packageTestPlugins(t, []ApkInfo{plugin})
+	checkSnapshots(2)
+}
@@ -0,0 +1,341 @@
+package packager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Journal of the filesystem changes of an unfinished packaging in the output directory. If taktool is interrupted,
+// the changes are rolled back, or finished if the transaction was already committed, on the next run.
+const journalFilename = ".taktool-journal.json"
+
+const (
+	journalRename = "rename"
+	journalRemove = "remove"
+	journalWrite  = "write"
+	journalAppend = "append"
+)
+
+// Filesystem change in the journal. Removed files are moved to a backup file
+// and new files are written to a temporary file until the transaction is committed. Appended files
+// are truncated back to their previous size if the transaction is rolled back. Paths are absolute.
+type journalStep struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// New path of renamed file, backup of removed file or temporary file of written file
+	Other string `json:"other"`
+	// Size of appended file before appending
+	Size int64 `json:"size,omitempty"`
+}
+
+type journal struct {
+	Committed bool          `json:"committed"`
+	Steps     []journalStep `json:"steps"`
+}
+
+// Transaction of filesystem changes that are either all done or all rolled back
+type transaction struct {
+	journalPath string
+	journal     journal
+	// Package file changes, written to the audit log with product.infz
+	changes []auditEntry
+}
+
+// Start a new transaction with the journal in the directory. Journal of an interrupted transaction must be recovered first.
+func beginTransaction(dir string) (*transaction, error) {
+	journalPath := filepath.Join(dir, journalFilename)
+	if _, err := os.Stat(journalPath); err == nil {
+		return nil, fmt.Errorf("journal %s of an interrupted transaction exists", journalPath)
+	}
+
+	tx := &transaction{journalPath: journalPath}
+	err := tx.saveJournal()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Run the changes in a new transaction. Changes are committed if do succeeds, otherwise they are rolled back.
+func inTransaction(dir string, do func(tx *transaction) error) error {
+	tx, err := beginTransaction(dir)
+	if err != nil {
+		return err
+	}
+
+	err = do(tx)
+	if err != nil {
+		rollbackErr := tx.rollback()
+		if rollbackErr != nil {
+			return fmt.Errorf("%w, rolling back failed: %w", err, rollbackErr)
+		}
+		fmt.Println("Packaging failed, all changes were rolled back")
+		return err
+	}
+
+	err = tx.commit()
+	if err != nil {
+		return fmt.Errorf("error committing changes: %w", err)
+	}
+	return nil
+}
+
+// Finish or roll back the transaction left in the journal file of the directory
+func recoverJournal(dir string) error {
+	journalPath := filepath.Join(dir, journalFilename)
+	data, err := os.ReadFile(journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading journal: %w", err)
+	}
+
+	tx := &transaction{journalPath: journalPath}
+	err = json.Unmarshal(data, &tx.journal)
+	if err != nil {
+		return fmt.Errorf("error parsing journal %s: %w", journalPath, err)
+	}
+
+	if tx.journal.Committed {
+		fmt.Println("Finishing interrupted packaging")
+		return tx.finish()
+	}
+	fmt.Println("Rolling back interrupted packaging")
+	return tx.rollback()
+}
+
+// Write journal to disk. Every step is saved before it is done.
+func (tx *transaction) saveJournal() error {
+	data, err := json.MarshalIndent(tx.journal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding journal: %w", err)
+	}
+
+	tempPath := tx.journalPath + ".tmp"
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	err = os.Rename(tempPath, tx.journalPath)
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return nil
+}
+
+// Add step to the journal
+func (tx *transaction) addStep(step journalStep) error {
+	var err error
+	step.Path, err = filepath.Abs(step.Path)
+	if err != nil {
+		return err
+	}
+	if step.Other != "" {
+		step.Other, err = filepath.Abs(step.Other)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.journal.Steps = append(tx.journal.Steps, step)
+	return tx.saveJournal()
+}
+
+// Rename file. The new path must not exist.
+func (tx *transaction) rename(oldPath, newPath string) error {
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("file %s already exists", newPath)
+	}
+
+	err := tx.addStep(journalStep{Op: journalRename, Path: oldPath, Other: newPath})
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Remove file. The file is kept as a backup until the transaction is committed.
+func (tx *transaction) remove(path string) error {
+	backupPath := path + ".taktool-removed"
+	if _, err := os.Stat(backupPath); err == nil {
+		return fmt.Errorf("backup file %s already exists", backupPath)
+	}
+
+	err := tx.addStep(journalStep{Op: journalRemove, Path: path, Other: backupPath})
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, backupPath)
+}
+
+// Write file. The file is written to a temporary file that replaces the file when the transaction is committed.
+func (tx *transaction) writeFile(path string, write func(w io.Writer) error) error {
+	tempPath := path + ".taktool-new"
+	if _, err := os.Stat(tempPath); err == nil {
+		return fmt.Errorf("temporary file %s already exists", tempPath)
+	}
+
+	err := tx.addStep(journalStep{Op: journalWrite, Path: path, Other: tempPath})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	err = write(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return file.Close()
+}
+
+// Append data to the file. The file is truncated back to its previous size if the transaction is rolled back.
+func (tx *transaction) appendFile(path string, data []byte) error {
+	size := int64(0)
+	if fileInfo, err := os.Stat(path); err == nil {
+		size = fileInfo.Size()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+
+	err := tx.addStep(journalStep{Op: journalAppend, Path: path, Size: size})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return file.Close()
+}
+
+// Write function for writeFile that writes the data
+func writeBytes(data []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		if err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+		return nil
+	}
+}
+
+// Write function for writeFile that copies the file
+func copyFile(sourcePath string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		f, err := os.Open(sourcePath)
+		if err != nil {
+			return fmt.Errorf("error opening file: %w", err)
+		}
+		defer f.Close()
+
+		_, err = io.Copy(w, f)
+		if err != nil {
+			return fmt.Errorf("error copying file: %w", err)
+		}
+		return nil
+	}
+}
+
+// Commit the transaction by replacing written files and deleting backups of removed files
+func (tx *transaction) commit() error {
+	tx.journal.Committed = true
+	err := tx.saveJournal()
+	if err != nil {
+		return err
+	}
+	return tx.finish()
+}
+
+// Do the remaining work of a committed transaction. It can be repeated if it is interrupted.
+func (tx *transaction) finish() error {
+	for _, step := range tx.journal.Steps {
+		switch step.Op {
+		case journalWrite:
+			if _, err := os.Stat(step.Other); err == nil {
+				err = os.Rename(step.Other, step.Path)
+				if err != nil {
+					return fmt.Errorf("error replacing %s: %w", step.Path, err)
+				}
+			}
+		case journalRemove:
+			err := os.Remove(step.Other)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing %s: %w", step.Other, err)
+			}
+		}
+	}
+	return os.Remove(tx.journalPath)
+}
+
+// Undo all changes of the transaction in reverse order. Steps that were not done are skipped.
+func (tx *transaction) rollback() error {
+	errs := []error{}
+	for _, step := range slices.Backward(tx.journal.Steps) {
+		switch step.Op {
+		case journalRename, journalRemove:
+			// Move file back if it was moved
+			if _, err := os.Stat(step.Path); err == nil {
+				continue
+			}
+			if _, err := os.Stat(step.Other); err != nil {
+				continue
+			}
+			err := os.Rename(step.Other, step.Path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error restoring %s: %w", step.Path, err))
+			}
+		case journalWrite:
+			err := os.Remove(step.Other)
+			if err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("error removing %s: %w", step.Other, err))
+			}
+		case journalAppend:
+			// File created by the transaction is removed
+			var err error
+			if step.Size == 0 {
+				err = os.Remove(step.Path)
+			} else {
+				err = os.Truncate(step.Path, step.Size)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("error restoring %s: %w", step.Path, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		// Keep the journal so that rollback can be tried again
+		return errors.Join(errs...)
+	}
+	return os.Remove(tx.journalPath)
+}
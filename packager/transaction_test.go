@@ -0,0 +1,315 @@
+package packager
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errTestFailure = errors.New("test failure")
+
+// Write the files by name to the directory
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Check that the directory has exactly the files by name
+func checkTestFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[entry.Name()] = string(data)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	files := map[string]string{"a.apk": "a", "b.apk": "b", "audit.log": "line 1\n"}
+	tests := []struct {
+		name string
+		do   func(tx *transaction, dir string) error
+	}{
+		{
+			name: "rename",
+			do: func(tx *transaction, dir string) error {
+				return tx.rename(filepath.Join(dir, "a.apk"), filepath.Join(dir, "c.apk"))
+			},
+		},
+		{
+			name: "remove",
+			do: func(tx *transaction, dir string) error {
+				return tx.remove(filepath.Join(dir, "a.apk"))
+			},
+		},
+		{
+			name: "write",
+			do: func(tx *transaction, dir string) error {
+				err := tx.writeFile(filepath.Join(dir, "a.apk"), writeBytes([]byte("new a")))
+				if err != nil {
+					return err
+				}
+				return tx.writeFile(filepath.Join(dir, "c.apk"), writeBytes([]byte("c")))
+			},
+		},
+		{
+			name: "append",
+			do: func(tx *transaction, dir string) error {
+				err := tx.appendFile(filepath.Join(dir, "audit.log"), []byte("line 2\n"))
+				if err != nil {
+					return err
+				}
+				return tx.appendFile(filepath.Join(dir, "new.log"), []byte("line 1\n"))
+			},
+		},
+		{
+			name: "all steps",
+			do: func(tx *transaction, dir string) error {
+				err := tx.remove(filepath.Join(dir, "b.apk"))
+				if err != nil {
+					return err
+				}
+				err = tx.rename(filepath.Join(dir, "a.apk"), filepath.Join(dir, "b.apk"))
+				if err != nil {
+					return err
+				}
+				err = tx.writeFile(filepath.Join(dir, "a.apk"), copyFile(filepath.Join(dir, "b.apk")))
+				if err != nil {
+					return err
+				}
+				return tx.appendFile(filepath.Join(dir, "audit.log"), []byte("line 2\n"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, files)
+
+			err := inTransaction(dir, func(tx *transaction) error {
+				err := tt.do(tx, dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return errTestFailure
+			})
+			if !errors.Is(err, errTestFailure) {
+				t.Fatalf("inTransaction() error = %v, want %v", err, errTestFailure)
+			}
+			checkTestFiles(t, dir, files)
+		})
+	}
+}
+
+func TestTransactionFailedStep(t *testing.T) {
+	tests := []struct {
+		name string
+		do   func(tx *transaction, dir string) error
+	}{
+		{
+			name: "rename to existing file",
+			do: func(tx *transaction, dir string) error {
+				return tx.rename(filepath.Join(dir, "a.apk"), filepath.Join(dir, "audit.log"))
+			},
+		},
+		{
+			name: "rename missing file",
+			do: func(tx *transaction, dir string) error {
+				return tx.rename(filepath.Join(dir, "missing.apk"), filepath.Join(dir, "c.apk"))
+			},
+		},
+		{
+			name: "remove missing file",
+			do: func(tx *transaction, dir string) error {
+				return tx.remove(filepath.Join(dir, "missing.apk"))
+			},
+		},
+		{
+			name: "write fails",
+			do: func(tx *transaction, dir string) error {
+				return tx.writeFile(filepath.Join(dir, "a.apk"), copyFile(filepath.Join(dir, "missing.apk")))
+			},
+		},
+		{
+			name: "append in missing directory",
+			do: func(tx *transaction, dir string) error {
+				return tx.appendFile(filepath.Join(dir, "missing", "audit.log"), []byte("line 2\n"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"a.apk": "a", "b.apk": "b", "audit.log": "line 1\n"}
+			writeTestFiles(t, dir, files)
+
+			// Steps done before the failed step are rolled back too
+			err := inTransaction(dir, func(tx *transaction) error {
+				err := tx.remove(filepath.Join(dir, "b.apk"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = tx.appendFile(filepath.Join(dir, "audit.log"), []byte("line 2\n"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = tx.writeFile(filepath.Join(dir, "c.apk"), writeBytes([]byte("c")))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return tt.do(tx, dir)
+			})
+			if err == nil {
+				t.Fatal("inTransaction() succeeded, want error")
+			}
+			checkTestFiles(t, dir, files)
+		})
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.apk": "a", "b.apk": "b", "audit.log": "line 1\n"})
+
+	err := inTransaction(dir, func(tx *transaction) error {
+		err := tx.remove(filepath.Join(dir, "b.apk"))
+		if err != nil {
+			return err
+		}
+		err = tx.rename(filepath.Join(dir, "a.apk"), filepath.Join(dir, "b.apk"))
+		if err != nil {
+			return err
+		}
+		err = tx.writeFile(filepath.Join(dir, "a.apk"), writeBytes([]byte("new a")))
+		if err != nil {
+			return err
+		}
+		return tx.appendFile(filepath.Join(dir, "audit.log"), []byte("line 2\n"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, map[string]string{"a.apk": "new a", "b.apk": "a", "audit.log": "line 1\nline 2\n"})
+}
+
+func TestRecoverJournal(t *testing.T) {
+	files := map[string]string{"a.apk": "a", "b.apk": "b", "audit.log": "line 1\n"}
+	committed := map[string]string{"a.apk": "new a", "c.apk": "a", "audit.log": "line 1\nline 2\n"}
+	tests := []struct {
+		name      string
+		committed bool
+		// Steps done before the interruption
+		steps int
+		want  map[string]string
+	}{
+		{name: "uncommitted", committed: false, steps: 4, want: files},
+		{name: "uncommitted in the middle", committed: false, steps: 2, want: files},
+		{name: "uncommitted before first step", committed: false, steps: 0, want: files},
+		{name: "committed", committed: true, steps: 4, want: committed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, files)
+
+			tx, err := beginTransaction(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			steps := []func() error{
+				func() error { return tx.remove(filepath.Join(dir, "b.apk")) },
+				func() error { return tx.rename(filepath.Join(dir, "a.apk"), filepath.Join(dir, "c.apk")) },
+				func() error { return tx.writeFile(filepath.Join(dir, "a.apk"), writeBytes([]byte("new a"))) },
+				func() error { return tx.appendFile(filepath.Join(dir, "audit.log"), []byte("line 2\n")) },
+			}
+			for _, step := range steps[:tt.steps] {
+				err := step()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Interrupted after saving the journal, committing does not get to finish
+			if tt.committed {
+				tx.journal.Committed = true
+				err := tx.saveJournal()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Journal of the interrupted transaction blocks new transactions
+			_, err = beginTransaction(dir)
+			if err == nil {
+				t.Fatal("beginTransaction() succeeded with a journal of an interrupted transaction")
+			}
+
+			err = recoverJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTestFiles(t, dir, tt.want)
+
+			// Nothing is left to recover
+			err = recoverJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRecoverJournalStepNotDone(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.apk": "a", "audit.log": "line 1\n"}
+	writeTestFiles(t, dir, files)
+
+	// Steps are saved to the journal before they are done
+	tx, err := beginTransaction(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []journalStep{
+		{Op: journalRename, Path: filepath.Join(dir, "a.apk"), Other: filepath.Join(dir, "c.apk")},
+		{Op: journalRemove, Path: filepath.Join(dir, "a.apk"), Other: filepath.Join(dir, "a.apk.taktool-removed")},
+		{Op: journalWrite, Path: filepath.Join(dir, "b.apk"), Other: filepath.Join(dir, "b.apk.taktool-new")},
+		{Op: journalAppend, Path: filepath.Join(dir, "audit.log"), Size: int64(len(files["audit.log"]))},
+	} {
+		err := tx.addStep(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = recoverJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, dir, files)
+}
+
+func TestRecoverJournalInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{journalFilename: "{"})
+
+	err := recoverJournal(dir)
+	if err == nil {
+		t.Error("recoverJournal() succeeded with an invalid journal")
+	}
+}
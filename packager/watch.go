@@ -0,0 +1,196 @@
+package packager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/pvarki/golang-tak-taktool/artifact"
+)
+
+// How often the directories are checked for changes
+const watchInterval = time.Second
+
+// Time without changes before packaging, so that a burst of changes is packaged once
+// and files that are still being copied are not read
+const watchQuietPeriod = 3 * time.Second
+
+// Time to wait before packaging again after packaging failed. The time doubles after every failure up to the maximum.
+const (
+	watchRetryMin = 5 * time.Second
+	watchRetryMax = 5 * time.Minute
+)
+
+// Size and modification time of a watched file
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// State of a watched directory
+type watchedDirectory struct {
+	dir        string
+	files      map[string]watchedFile
+	lastChange time.Time
+	changed    bool
+	// Retry of failed packaging
+	failed    bool
+	retryAt   time.Time
+	retryWait time.Duration
+}
+
+// Package plugins in the directories and package them again whenever package files or custom images are added,
+// replaced or removed, or pins are changed. Failed packaging is retried with a growing delay. inDirectory runs
+// the function in the directory with the options of the directory. Runs until interrupted.
+func WatchPlugins(dirs []string, inDirectory func(dir string, do func(opts PluginsOptions)) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	watched := []*watchedDirectory{}
+	for _, dir := range dirs {
+		watched = append(watched, &watchedDirectory{dir: dir})
+	}
+
+	packageOnce := func(w *watchedDirectory, opts PluginsOptions) {
+		if len(dirs) > 1 {
+			fmt.Println("Repository:", w.dir)
+		}
+		err := PackagePlugins(opts)
+		// Skipped files are listed in product.failures.txt, and they are read again when they change
+		if errors.Is(err, ErrPackagesSkipped) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			err = nil
+		}
+		if err != nil {
+			w.retryWait = min(max(w.retryWait*2, watchRetryMin), watchRetryMax)
+			w.failed = true
+			w.retryAt = time.Now().Add(w.retryWait)
+			fmt.Fprintf(os.Stderr, "Error creating plugins package: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Trying again in %s\n", w.retryWait)
+		} else {
+			w.failed = false
+			w.retryWait = 0
+		}
+		// Renamed and removed plugins are not changes
+		files, err := listWatchedFiles(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading directory: %v\n", err)
+		}
+		w.files = files
+		w.changed = false
+	}
+
+	for _, w := range watched {
+		err := inDirectory(w.dir, func(opts PluginsOptions) {
+			packageOnce(w, opts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("Watching for changes, press Ctrl+C to stop")
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		for _, w := range watched {
+			err := inDirectory(w.dir, func(opts PluginsOptions) {
+				current, err := listWatchedFiles(opts)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading directory %s: %v\n", w.dir, err)
+					return
+				}
+
+				// Growing files keep the change going until they are fully copied
+				if !maps.Equal(current, w.files) {
+					w.files = current
+					w.lastChange = time.Now()
+					w.changed = true
+					return
+				}
+
+				if w.changed && time.Since(w.lastChange) >= watchQuietPeriod {
+					fmt.Println("Changes found, packaging plugins")
+					packageOnce(w, opts)
+				} else if w.failed && time.Now().After(w.retryAt) {
+					fmt.Println("Packaging plugins again")
+					packageOnce(w, opts)
+				}
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+	}
+}
+
+// List package files and custom images with their sizes and modification times
+func listWatchedFiles(opts PluginsOptions) (map[string]watchedFile, error) {
+	files, err := listPackageFiles(opts.ApkDir, opts.Recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	watched := map[string]watchedFile{}
+	addFile := func(filePath string) error {
+		fileInfo, err := os.Stat(filePath)
+		if os.IsNotExist(err) {
+			// Removed while listing
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		watched[filePath] = watchedFile{size: fileInfo.Size(), modTime: fileInfo.ModTime()}
+		return nil
+	}
+
+	for _, filePath := range files {
+		if artifact.ForFile(filePath) == nil && !isBundleFile(filePath) {
+			continue
+		}
+		err = addFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Changed pins hold back or release plugins
+	if opts.PinsFile != "" {
+		err = addFile(opts.PinsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Changed descriptions change product.infz
+	err = addFile(descriptionsFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := filepath.Glob(filepath.Join("images", "*.png"))
+	if err != nil {
+		return nil, err
+	}
+	for _, imagePath := range images {
+		err = addFile(imagePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return watched, nil
+}